@@ -0,0 +1,188 @@
+// Command xpressfmt formats xpress templates and expressions.
+//
+// Without an explicit path, it processes the standard input. Given a file,
+// it operates on that file; given a directory, it operates on all files
+// with the extension set by -ext in that directory, recursively.
+//
+// Usage:
+//
+//	xpressfmt [flags] [path ...]
+//
+// The flags are:
+//
+//	-d
+//		Display diffs instead of rewriting files.
+//	-w
+//		Write result to (source) file instead of stdout.
+//	-e
+//		Treat input as bare expressions instead of templates.
+//	-left, -right
+//		Template delimiters. Defaults to "@{{" and "}}".
+//	-indent, -width
+//		Layout of array and map literals that do not fit on one line.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+var (
+	write      = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	doDiff     = flag.Bool("d", false, "display diffs instead of rewriting files")
+	expression = flag.Bool("e", false, "treat input as bare expressions instead of templates")
+	extension  = flag.String("ext", ".xpr", "extension of the files formatted when walking directories")
+	leftDelim  = flag.String("left", parser.DefaultLeftDelim, "left template delimiter")
+	rightDelim = flag.String("right", parser.DefaultRightDelim, "right template delimiter")
+	indent     = flag.String("indent", "\t", "indentation of multi-line array and map literals")
+	width      = flag.Int("width", 80, "line width above which array and map literals are split")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: xpressfmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "xpressfmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		if err := processFile("<standard input>", os.Stdin, os.Stdout); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+
+	for _, path := range flag.Args() {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			report(err)
+		case info.IsDir():
+			walkDir(path)
+		default:
+			if err := processFile(path, nil, os.Stdout); err != nil {
+				report(err)
+			}
+		}
+	}
+	os.Exit(exitCode)
+}
+
+var exitCode = 0
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 2
+}
+
+func walkDir(root string) {
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			report(err)
+			return nil
+		}
+		if entry.IsDir() || filepath.Ext(path) != *extension {
+			return nil
+		}
+		if err := processFile(path, nil, os.Stdout); err != nil {
+			report(err)
+		}
+		return nil
+	})
+	if err != nil {
+		report(err)
+	}
+}
+
+// processFile formats the file at filename, reading it from in when given.
+func processFile(filename string, in io.Reader, out io.Writer) error {
+	var src []byte
+	var err error
+	if in == nil {
+		src, err = os.ReadFile(filename)
+	} else {
+		src, err = io.ReadAll(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := format(string(src))
+	if err != nil {
+		return fmt.Errorf("%s:\n%w", filename, err)
+	}
+	if bytes.Equal(src, []byte(res)) {
+		if !*write && !*doDiff {
+			_, err = io.WriteString(out, res)
+		}
+		return err
+	}
+
+	if *write {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filename, []byte(res), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if *doDiff {
+		_, err = io.WriteString(out, diff("a/"+filename, "b/"+filename, string(src), res))
+		return err
+	}
+	if !*write {
+		_, err = io.WriteString(out, res)
+	}
+	return err
+}
+
+type formatError struct {
+	errs   parser.ErrorList
+	source string
+}
+
+func (e *formatError) Error() string {
+	return strings.TrimRight(e.errs.Format(e.source), "\n")
+}
+
+func format(src string) (string, error) {
+	p := parser.NewParserWithOptions(src, parser.ParserOptions{
+		LeftDelim:  *leftDelim,
+		RightDelim: *rightDelim,
+		Expression: *expression,
+	})
+	ast, errs := p.ParseAll()
+	if len(errs) > 0 {
+		return "", &formatError{errs: errs, source: src}
+	}
+	res, err := parser.PrintWithOptions(ast, parser.PrinterOptions{
+		LeftDelim:  *leftDelim,
+		RightDelim: *rightDelim,
+		Indent:     *indent,
+		LineWidth:  *width,
+	})
+	if err != nil {
+		return "", err
+	}
+	if *expression {
+		// Files end with a newline, which the parser does not keep for
+		// bare expressions.
+		res += "\n"
+	}
+	return res, nil
+}
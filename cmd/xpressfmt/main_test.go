@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// golden compares got with the content of testdata/name, or overwrites it
+// with -update.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		assert.Nil(t, os.WriteFile(path, []byte(got), 0o644))
+		return
+	}
+	expect, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expect), got)
+}
+
+// setFlag sets a boolean flag for the duration of the test.
+func setFlag(t *testing.T, target *bool, value bool) {
+	old := *target
+	*target = value
+	t.Cleanup(func() { *target = old })
+}
+
+func TestDiffFlag(t *testing.T) {
+	setFlag(t, doDiff, true)
+	out := bytes.Buffer{}
+	assert.Nil(t, processFile(filepath.Join("testdata", "template.xpr"), nil, &out))
+	golden(t, "template.diff", out.String())
+
+	// Formatted files produce no diff.
+	out.Reset()
+	assert.Nil(t, processFile(filepath.Join("testdata", "template.golden"), nil, &out))
+	assert.Equal(t, "", out.String())
+}
+
+func TestWriteFlag(t *testing.T) {
+	setFlag(t, write, true)
+	src, err := os.ReadFile(filepath.Join("testdata", "template.xpr"))
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "template.xpr")
+	assert.Nil(t, os.WriteFile(path, src, 0o600))
+
+	out := bytes.Buffer{}
+	assert.Nil(t, processFile(path, nil, &out))
+	assert.Equal(t, "", out.String())
+	res, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	golden(t, "template.golden", string(res))
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
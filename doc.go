@@ -75,6 +75,174 @@
 //	// "@{{ 1 + 2 }}" will return number `3`
 //	// "@{{ 1 + 2 }} "  will return string `3 `.
 //
+// # Operators
+//
+// From the lowest to the highest precedence:
+//
+//	x => a, (x, y) => a     // lambda
+//	a ?? b, a ?: b          // nil coalescing, falsy coalescing
+//	a ? b : c               // ternary
+//	a || b, a or b          // logical or
+//	a && b, a and b         // logical and
+//	not a                   // logical not
+//	a == b, a != b          // equality
+//	a < b, a <= b, a > b, a >= b, a in b, a not in b
+//	a + b, a - b
+//	a * b, a / b, a % b, a // b
+//	!a, -a
+//	a ** b                  // right-associative
+//	a.b, a?.b, a[b], a(b)
+//
+// `??` only falls back to the right operand when the left operand is nil, so `false ?? 1`
+// is `false`. `?:` falls back whenever the left operand is falsy (nil or false).
+//
+// `==` compares values deeply: numbers by value whatever their Go type, so `int(3) == 3.0`,
+// arrays and maps element by element, and structs field by field. Go types can define
+// their own equality by implementing parser.Equaler:
+//
+//	func (m Money) Equal(other interface{}) bool { ... }
+//
+// # Numbers
+//
+// By default every number is a float64, so integers above 2^53 lose precision. With
+// SetNumberMode(parser.IntegerNumbers), integer literals are int64 and arithmetic on two
+// integers of any Go integer type gives an int64, or an error if it overflows. `/` gives a
+// float64, and so does any operation with a float operand. parser.DecimalNumbers also keeps
+// literals with a fractional part exact, as a *big.Rat, and `/` then gives a *big.Rat too:
+//
+//	evaluator.SetNumberMode(parser.DecimalNumbers)
+//	// "@{{ 0.1 + 0.2 }}" will return string `0.3`
+//
+// # Dates and times
+//
+// Duration literals combine a number and a unit, such as `90s`, `1.5h` or `2h30m`. Units are
+// ns, us (or µs), ms, s, m, h and d, a day being 24 hours. Durations are time.Duration and
+// times are time.Time, and the operators work on both:
+//
+//	// "@{{ deadline - now() < 1d }}" is true if the deadline is less than a day away
+//	// "@{{ start + 2h30m }}", "@{{ end - start }}", "@{{ timeout * 2 }}"
+//
+// Comparisons and `==` compare times chronologically, regardless of their time zone. The
+// built-in functions now(), date(layout, s), format(t, layout) and timezone(t, name) read,
+// parse, format and convert times. Layouts are Go reference layouts such as "2006-01-02",
+// or the names RFC3339, RFC1123, RFC822, Kitchen, DateTime, DateOnly and TimeOnly. Members
+// shadow built-ins of the same name, and SetClock replaces the clock that now() reads:
+//
+//	evaluator.SetClock(func() time.Time { return fixedTime })
+//
+// # Lambdas
+//
+// Lambdas such as `x => x.price` or `(a, b) => a + b` evaluate to a *parser.Closure that
+// captures the variables in scope. Closures can be called from the template, and are
+// converted to the function type of the parameter when passed to a member function:
+//
+//	evaluator.AddMember("filter", func(items []Item, keep func(interface{}) bool) []Item { ... })
+//	// "@{{ filter(items, x => x.active) }}"
+//
+// # Built-in methods
+//
+// Arrays and slices of any Go type have `length` and the methods map, filter, reduce, find,
+// some, every, includes, indexOf, join, slice, sort, reverse, unique, flat, sum, min, max and
+// groupBy. They work like their javascript namesakes, but never modify the array they are
+// called on. Callbacks can be lambdas or member functions:
+//
+//	// "@{{ orders.filter(o => o.paid).sum(o => o.total) }}"
+//
+// Maps have keys, values, entries, has, merge and pick. Keys and values are listed in the
+// order of the keys. A key of the map takes precedence over a method of the same name.
+//
+// Strings have upper, lower, trim, trimStart, trimEnd, split, replace, replaceAll,
+// startsWith, endsWith, contains, padStart, padEnd, repeat, substring, charAt and indexOf.
+// `length` and indexes count characters rather than bytes, and replace only replaces the
+// first occurrence, as in javascript:
+//
+//	// "@{{ name.split(' ').map(w => w.charAt(0).upper()).join('') }}"
+//
+// # Delimiters
+//
+// Templates use "@{{" and "}}" as delimiters by default. Use parser.NewParserWithOptions
+// to pick different delimiters when embedding templates in files that already use them:
+//
+//	p := parser.NewParserWithOptions("Hello, ${ name }!", parser.ParserOptions{
+//		LeftDelim:  "${",
+//		RightDelim: "}",
+//	})
+//
+// # Expressions
+//
+// Bare expressions that are not wrapped in delimiters, such as rule conditions, can be
+// parsed with parser.ParseExpression or parser.NewExpressionParser:
+//
+//	ast := parser.ParseExpression(`user.age > 18 && user.country == "NG"`)
+//
+// # Synchronous evaluation
+//
+// By default, Evaluate runs each evaluation on its own goroutine so that the timeout can be
+// enforced even if a member function blocks. SetSynchronous(true) runs evaluations on the
+// caller's goroutine instead, checking the timeout and the context between steps, which is
+// much cheaper for short templates:
+//
+//	evaluator.SetSynchronous(true)
+//
+// # Limits
+//
+// Templates written by untrusted users can be bounded beyond the timeout. Each limit that is
+// exceeded is reported with its own error type, such as *parser.StepLimitError or
+// *parser.OutputLimitError:
+//
+//	evaluator.SetLimits(parser.Limits{
+//		MaxSteps:          10000,
+//		MaxDepth:          64,
+//		MaxStringLength:   1 << 16,
+//		MaxCollectionSize: 1000,
+//		MaxOutputSize:     1 << 20,
+//	})
+//	ast := parser.NewParserWithOptions(template, parser.ParserOptions{MaxDepth: 64}).Parse()
+//
+// # Access policy
+//
+// By default an expression can read any exported field and call any exported method of the
+// values it reaches. An access policy restricts them, whether they are read with `.`, with `[]`
+// or called. Denied members raise a *parser.AccessError, and fields tagged `xpress:"-"` are
+// hidden whatever the policy:
+//
+//	evaluator.SetAccessPolicy(parser.NewAllowlist().
+//		AllowFields(reflect.TypeOf(User{}), "Name", "Email").
+//		AllowMethods(reflect.TypeOf(time.Time{}), "Format", "Before", "After"))
+//
+// parser.NewDenylist works the other way round, allowing everything but what it denies.
+// Functions that pass values on to encoders, such as json.encode and strings.format, convert
+// them with Export first, so that structs only show the fields the policy allows.
+//
+// # Compiling
+//
+// Templates that are evaluated many times can be compiled once into a parser.Program, which
+// evaluates like Evaluate but without walking the tree. Variables passed to Run take
+// precedence over the evaluator's members:
+//
+//	program, err := evaluator.Compile(ast)
+//	...
+//	result, err := program.Run(ctx, map[string]interface{}{"user": user})
+//
+// # Analysis
+//
+// parser.Analyze reports the variables, access paths and function calls an expression
+// references, with their positions, so the members it needs can be fetched or validated
+// before evaluating it:
+//
+//	analysis := parser.Analyze(ast)
+//	if undefined := analysis.Undefined(members); len(undefined) > 0 {
+//		// reject the template
+//	}
+//
+// # Formatting
+//
+// parser.Print turns a parsed template or expression back into canonical source, with
+// normalized spacing and quoting and only the parentheses that are needed. The xpressfmt
+// command wraps it:
+//
+//	go run github.com/nonsocode/xpress/cmd/xpressfmt -w templates/
+//
 // # Members
 //
 // Members are variables and functions that can be accessed from the template. When defining
@@ -85,6 +253,57 @@
 // and the second value will be used as an error. If the error is not nil, the evaluation will
 // be aborted and the error will be returned.
 //
+// Per-request data does not need to be added to a shared evaluator. EvaluateWith layers
+// variables over the members for a single evaluation, and EvaluateIn accepts a parser.Scope,
+// whose lookups fall through to its parent scopes and then to the members:
+//
+//	site := parser.NewScope(nil, map[string]interface{}{"site": siteConfig})
+//	request := parser.NewScope(site, map[string]interface{}{"user": currentUser})
+//	result, err := evaluator.EvaluateIn(ctx, ast, request)
+//
+// Struct fields are read by their Go name, or by the name in their `xpress:"name"` tag.
+// SetFieldNaming can fall back to json tags, lower the first letter of untagged names, or
+// match names regardless of case:
+//
+//	evaluator.SetFieldNaming(parser.FieldNaming{JSONTags: true, CaseInsensitive: true})
+//
+// # Standard library
+//
+// The stdlib package provides modules of functions: math, strings, time, json, regex,
+// encoding, crypto, collections and uuid. Use installs each module as a member named after
+// it, so its functions are called as `math.round(x, 2)`:
+//
+//	evaluator.Use(stdlib.All()...)
+//	// or, for templates that render the same output on every run:
+//	evaluator.SetClock(fixedClock)
+//	evaluator.Use(stdlib.UUID(rand.New(rand.NewSource(1))))
+//
+// Applications can ship their own modules as a parser.Module. Every parser.Function lists
+// its parameters and result, and Signature formats them for tooling such as editors. Functions
+// whose first parameter is a context.Context can get the calling evaluator from it with
+// parser.EvaluatorFrom, to read its Limits or Export the values they encode.
+//
+// # Errors
+//
+// Syntax errors are reported as *parser.ParseError, which carries the line, column and offset
+// of the offending token. Errors raised while evaluating are wrapped in *parser.RuntimeError,
+// which carries the span of the expression that failed. Both can render the offending line of
+// the template with the problem underlined:
+//
+//	var runtimeErr *parser.RuntimeError
+//	if errors.As(err, &runtimeErr) {
+//		fmt.Print(runtimeErr.Format(template))
+//	}
+//
+// By default, reading an undefined variable, a missing property or a missing key gives nil.
+// SetStrict(true) makes it a *parser.UndefinedError naming the path instead, except where the
+// template opts in to absent values: in the chain before `?.` and the property right after
+// it, and in the chain on the left of `??` or `?:`:
+//
+//	evaluator.SetStrict(true)
+//	evaluator.Evaluate(ctx, parser.NewParser("@{{ user.nmae }}").Parse())          // 'user.nmae' is not defined
+//	evaluator.Evaluate(ctx, parser.NewParser("@{{ user.nickname ?? user.name }}").Parse()) // ok
+//
 // # Benchmarks
 //
 //	goos: linux
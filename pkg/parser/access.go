@@ -0,0 +1,248 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+)
+
+type (
+	// AccessPolicy decides which fields and methods of Go values an
+	// expression can reach, whether it reads them with `.`, with `[]` or
+	// calls them. t is the type of the value the member belongs to, with
+	// pointers dereferenced, so the same policy applies to `T` and `*T`, and
+	// name is its Go name, whatever FieldNaming expressions use. A member
+	// promoted from an embedded struct must be allowed on the struct that
+	// declares it and on every struct it is promoted through.
+	AccessPolicy interface {
+		AllowField(t reflect.Type, name string) bool
+		AllowMethod(t reflect.Type, name string) bool
+	}
+
+	// Allowlist is an AccessPolicy that denies every field and method that
+	// was not allowed explicitly.
+	Allowlist struct {
+		fields  members
+		methods members
+	}
+
+	// Denylist is an AccessPolicy that allows every field and method that
+	// was not denied explicitly.
+	Denylist struct {
+		fields  members
+		methods members
+	}
+
+	// AccessError is returned when an expression reads a field or method
+	// that the access policy of the evaluator denies.
+	AccessError struct {
+		Type   reflect.Type
+		Name   string
+		Method bool
+	}
+
+	// members maps types to the names of their fields or methods. A nil
+	// set stands for all of them.
+	members map[reflect.Type]map[string]bool
+)
+
+// SetAccessPolicy restricts the fields and methods expressions can reach.
+// A nil policy, the default, allows all exported fields and methods. Fields
+// tagged `xpress:"-"` are hidden whatever the policy.
+func (i *Evaluator) SetAccessPolicy(policy AccessPolicy) {
+	i.policy = policy
+}
+
+// NewAllowlist returns an Allowlist that allows nothing yet.
+func NewAllowlist() *Allowlist {
+	return &Allowlist{fields: members{}, methods: members{}}
+}
+
+// AllowType allows all the fields and methods of t.
+func (a *Allowlist) AllowType(t reflect.Type) *Allowlist {
+	a.fields.addType(t)
+	a.methods.addType(t)
+	return a
+}
+
+// AllowFields allows the fields of t called names.
+func (a *Allowlist) AllowFields(t reflect.Type, names ...string) *Allowlist {
+	a.fields.add(t, names)
+	return a
+}
+
+// AllowMethods allows the methods of t called names.
+func (a *Allowlist) AllowMethods(t reflect.Type, names ...string) *Allowlist {
+	a.methods.add(t, names)
+	return a
+}
+
+func (a *Allowlist) AllowField(t reflect.Type, name string) bool {
+	return a.fields.has(t, name)
+}
+
+func (a *Allowlist) AllowMethod(t reflect.Type, name string) bool {
+	return a.methods.has(t, name)
+}
+
+// NewDenylist returns a Denylist that denies nothing yet.
+func NewDenylist() *Denylist {
+	return &Denylist{fields: members{}, methods: members{}}
+}
+
+// DenyType denies all the fields and methods of t.
+func (d *Denylist) DenyType(t reflect.Type) *Denylist {
+	d.fields.addType(t)
+	d.methods.addType(t)
+	return d
+}
+
+// DenyFields denies the fields of t called names.
+func (d *Denylist) DenyFields(t reflect.Type, names ...string) *Denylist {
+	d.fields.add(t, names)
+	return d
+}
+
+// DenyMethods denies the methods of t called names.
+func (d *Denylist) DenyMethods(t reflect.Type, names ...string) *Denylist {
+	d.methods.add(t, names)
+	return d
+}
+
+func (d *Denylist) AllowField(t reflect.Type, name string) bool {
+	return !d.fields.has(t, name)
+}
+
+func (d *Denylist) AllowMethod(t reflect.Type, name string) bool {
+	return !d.methods.has(t, name)
+}
+
+func (e *AccessError) Error() string {
+	kind := "field"
+	if e.Method {
+		kind = "method"
+	}
+	return fmt.Sprintf("access to %s '%s' of %s is denied", kind, e.Name, e.Type)
+}
+
+func (m members) addType(t reflect.Type) {
+	m[indirectType(t)] = nil
+}
+
+func (m members) add(t reflect.Type, names []string) {
+	t = indirectType(t)
+	set, ok := m[t]
+	if ok && set == nil {
+		return
+	}
+	if set == nil {
+		set = map[string]bool{}
+		m[t] = set
+	}
+	for _, name := range names {
+		set[name] = true
+	}
+}
+
+func (m members) has(t reflect.Type, name string) bool {
+	set, ok := m[t]
+	return ok && (set == nil || set[name])
+}
+
+// member returns the field or method called name of a struct or a pointer,
+// or errUndefined if it has none.
+func (i *Evaluator) member(value reflect.Value, name string) (interface{}, error) {
+	t := indirectType(value.Type())
+	if t.Kind() == reflect.Struct {
+		if field, ok := i.naming.field(t, name); ok {
+			if owner, ok := i.deniedField(t, field); ok {
+				return nil, &AccessError{Type: owner, Name: field.Name}
+			}
+			if value.Kind() == reflect.Ptr && value.IsNil() {
+				return nil, fmt.Errorf("cannot get property '%s' of nil", name)
+			}
+			v, err := reflect.Indirect(value).FieldByIndexErr(field.Index)
+			if err != nil {
+				return nil, err
+			}
+			return v.Interface(), nil
+		}
+	}
+	if method, ok := getMethodFromStructOrPointer(value, name); ok {
+		if i.policy != nil {
+			for _, owner := range methodOwners(t, name) {
+				if !i.policy.AllowMethod(owner, name) {
+					return nil, &AccessError{Type: owner, Name: name, Method: true}
+				}
+			}
+		}
+		return method, nil
+	}
+	return nil, errUndefined
+}
+
+// deniedField returns the struct that the access policy denies field of the
+// struct t on, if any.
+func (i *Evaluator) deniedField(t reflect.Type, field reflect.StructField) (reflect.Type, bool) {
+	if i.policy == nil {
+		return nil, false
+	}
+	for _, owner := range fieldOwners(t, field) {
+		if !i.policy.AllowField(owner, field.Name) {
+			return owner, true
+		}
+	}
+	return nil, false
+}
+
+// fieldOwners returns the struct t followed by the embedded structs field
+// is promoted through, the last of which declares it.
+func fieldOwners(t reflect.Type, field reflect.StructField) []reflect.Type {
+	owners := []reflect.Type{t}
+	for _, index := range field.Index[:len(field.Index)-1] {
+		t = indirectType(t.Field(index).Type)
+		owners = append(owners, t)
+	}
+	return owners
+}
+
+// methodOwners returns t followed by the embedded types the method called
+// name is promoted through, the last of which declares it.
+func methodOwners(t reflect.Type, name string) []reflect.Type {
+	owners := []reflect.Type{t}
+	for t.Kind() == reflect.Struct {
+		embedded, ok := embeddedWithMethod(t, name)
+		if !ok {
+			break
+		}
+		t = embedded
+		owners = append(owners, t)
+	}
+	return owners
+}
+
+// embeddedWithMethod returns the type of the field embedded in the struct t
+// whose method set, or that of a pointer to it, has a method called name.
+func embeddedWithMethod(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.Anonymous {
+			continue
+		}
+		embedded := indirectType(field.Type)
+		methods := reflect.PtrTo(embedded)
+		if embedded.Kind() == reflect.Interface {
+			methods = embedded
+		}
+		if _, ok := methods.MethodByName(name); ok {
+			return embedded, true
+		}
+	}
+	return nil, false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
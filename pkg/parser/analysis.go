@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+type (
+	// Reference is a use of a member in an expression. Name is a variable
+	// name, or an access path such as `user.address.city` or
+	// `orders[0].total`. Indexes that are not literals are written `[*]`.
+	Reference struct {
+		Name string
+		Span Span
+	}
+
+	// Analysis lists the members an expression depends on, in source order.
+	Analysis struct {
+		// Variables holds every free variable.
+		Variables []Reference
+		// Paths holds the longest access paths that are read, rooted at a
+		// variable. Paths that are called are listed in Calls instead.
+		Paths []Reference
+		// Calls holds the names and paths of the called functions.
+		Calls []Reference
+	}
+
+	analyzer struct {
+		analysis *Analysis
+		// bound counts the lambdas in scope that have a parameter of each
+		// name. Bound names are not free variables.
+		bound map[string]int
+	}
+)
+
+// Analyze returns the variables, access paths and function calls referenced
+// by expr, so that the members they need can be known before evaluating it.
+func Analyze(expr Expr) *Analysis {
+	a := &analyzer{analysis: &Analysis{
+		Variables: make([]Reference, 0),
+		Paths:     make([]Reference, 0),
+		Calls:     make([]Reference, 0),
+	}, bound: make(map[string]int)}
+	a.expr(expr)
+	sortReferences(a.analysis.Variables)
+	sortReferences(a.analysis.Paths)
+	sortReferences(a.analysis.Calls)
+	return a.analysis
+}
+
+// Names returns the distinct names of the free variables, in order of first use.
+func (a *Analysis) Names() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, variable := range a.Variables {
+		if !seen[variable.Name] {
+			seen[variable.Name] = true
+			names = append(names, variable.Name)
+		}
+	}
+	return names
+}
+
+// Undefined returns the uses of the free variables that are neither in
+// members nor built-in functions, such as now.
+func (a *Analysis) Undefined(members map[string]interface{}) []Reference {
+	undefined := make([]Reference, 0)
+	for _, variable := range a.Variables {
+		_, isMember := members[variable.Name]
+		_, isBuiltin := builtins[variable.Name]
+		if !isMember && !isBuiltin {
+			undefined = append(undefined, variable)
+		}
+	}
+	return undefined
+}
+
+func (a *analyzer) expr(expr Expr) {
+	switch e := expr.(type) {
+	case nil:
+		return
+	case *Lambda:
+		for _, param := range e.params {
+			a.bound[param.lexeme]++
+		}
+		a.expr(e.body)
+		for _, param := range e.params {
+			a.bound[param.lexeme]--
+		}
+		return
+	case *Call:
+		if name, ok := a.path(e.callee); ok {
+			a.analysis.Calls = append(a.analysis.Calls, Reference{Name: name, Span: e.callee.Span()})
+		} else {
+			a.expr(e.callee)
+		}
+		for _, arg := range e.arguments {
+			a.expr(arg)
+		}
+		return
+	case *Variable, *Get, *Index, *Optional:
+		if name, ok := a.path(e); ok {
+			if _, isVariable := e.(*Variable); !isVariable {
+				a.analysis.Paths = append(a.analysis.Paths, Reference{Name: name, Span: e.Span()})
+			}
+			return
+		}
+	}
+	for _, child := range Children(expr) {
+		a.expr(child)
+	}
+}
+
+// path returns the access path of expr if it is a chain of property
+// accesses and indexes rooted at a free variable. The root variable and the
+// expressions of dynamic indexes are analyzed along the way.
+func (a *analyzer) path(expr Expr) (string, bool) {
+	builder := strings.Builder{}
+	root, dynamic, ok := accessPath(expr, &builder)
+	if !ok || a.bound[root.name.lexeme] > 0 {
+		return "", false
+	}
+	a.analysis.Variables = append(a.analysis.Variables, Reference{Name: root.name.lexeme, Span: root.Span()})
+	for _, index := range dynamic {
+		a.expr(index)
+	}
+	return builder.String(), true
+}
+
+func accessPath(expr Expr, builder *strings.Builder) (*Variable, []Expr, bool) {
+	switch e := expr.(type) {
+	case *Variable:
+		builder.WriteString(e.name.lexeme)
+		return e, nil, true
+	case *Grouping:
+		return accessPath(e.expression, builder)
+	case *Optional:
+		return accessPath(e.left, builder)
+	case *Get:
+		root, dynamic, ok := accessPath(e.object, builder)
+		builder.WriteString(".")
+		builder.WriteString(e.name.lexeme)
+		return root, dynamic, ok
+	case *Index:
+		root, dynamic, ok := accessPath(e.object, builder)
+		if literal, isLiteral := e.index.(*Literal); isLiteral {
+			builder.WriteString("[")
+			if key, isString := literal.value.(string); isString {
+				builder.WriteString(quote(key))
+			} else {
+				builder.WriteString(literal.raw)
+			}
+			builder.WriteString("]")
+		} else {
+			builder.WriteString("[*]")
+			dynamic = append(dynamic, e.index)
+		}
+		return root, dynamic, ok
+	}
+	return nil, nil, false
+}
+
+func sortReferences(references []Reference) {
+	sort.SliceStable(references, func(i, j int) bool {
+		return references[i].Span.Start.Offset < references[j].Span.Start.Offset
+	})
+}
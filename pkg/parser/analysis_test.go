@@ -0,0 +1,67 @@
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func names(references []parser.Reference) []string {
+	result := make([]string, len(references))
+	for i, reference := range references {
+		result[i] = reference.Name
+	}
+	return result
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		input     string
+		variables []string
+		paths     []string
+		calls     []string
+	}{
+		{"1 + 2", []string{}, []string{}, []string{}},
+		{"a + a", []string{"a", "a"}, []string{}, []string{}},
+		{"user.address.city", []string{"user"}, []string{"user.address.city"}, []string{}},
+		{"orders[0].total", []string{"orders"}, []string{"orders[0].total"}, []string{}},
+		{"orders['first'].total", []string{"orders"}, []string{`orders["first"].total`}, []string{}},
+		{"orders[i.j].total", []string{"orders", "i"}, []string{"orders[*].total", "i.j"}, []string{}},
+		{"user?.address?.[0]", []string{"user"}, []string{"user.address[0]"}, []string{}},
+		{"(user).name", []string{"user"}, []string{"user.name"}, []string{}},
+		{"greet(user.name, 1)", []string{"greet", "user"}, []string{"user.name"}, []string{"greet"}},
+		{"user.format(date).length", []string{"user", "date"}, []string{}, []string{"user.format"}},
+		{"(a ?? b).c", []string{"a", "b"}, []string{}, []string{}},
+		{"{key: value, [k]: [x.y]}", []string{"value", "k", "x"}, []string{"x.y"}, []string{}},
+		{"items.filter(x => x.active && x[key] > min)", []string{"items", "key", "min"}, []string{}, []string{"items.filter"}},
+		{"(x => x.y)(x)", []string{"x"}, []string{}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			analysis := parser.Analyze(parser.ParseExpression(test.input))
+			assert.Equal(t, test.variables, names(analysis.Variables))
+			assert.Equal(t, test.paths, names(analysis.Paths))
+			assert.Equal(t, test.calls, names(analysis.Calls))
+		})
+	}
+}
+
+func TestAnalyzeTemplate(t *testing.T) {
+	source := "Hi @{{ user.name }}, @{{ greet(user) }} @{{ total }}"
+	analysis := parser.Analyze(parser.NewParser(source).Parse())
+	assert.Equal(t, []string{"user", "greet", "total"}, analysis.Names())
+
+	path := analysis.Paths[0]
+	assert.Equal(t, "user.name", path.Name)
+	assert.Equal(t, "user.name", source[path.Span.Start.Offset:path.Span.End.Offset])
+	assert.Equal(t, 1, path.Span.Start.Line)
+	assert.Equal(t, 8, path.Span.Start.Column)
+
+	undefined := analysis.Undefined(map[string]interface{}{"user": nil, "greet": nil})
+	assert.Equal(t, []string{"total"}, names(undefined))
+	assert.Equal(t, "total", source[undefined[0].Span.Start.Offset:undefined[0].Span.End.Offset])
+
+	analysis = parser.Analyze(parser.NewParser("@{{ format(timezone(now(), zone), 'Kitchen') }}").Parse())
+	assert.Equal(t, []string{"zone"}, names(analysis.Undefined(nil)))
+}
@@ -0,0 +1,523 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type (
+	// builtin is a method of the values of a Go kind, such as the `map` of
+	// arrays. It is called with the value it was read from.
+	builtin func(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error)
+
+	// method is a builtin with the number of arguments it accepts. A
+	// negative max means any number.
+	method struct {
+		min, max int
+		call     builtin
+	}
+)
+
+// arrayMethods are the methods of slices and arrays. They never modify the
+// array they are called on: those that return arrays return new ones.
+var arrayMethods map[string]method
+
+// mapMethods are the methods of maps. Keys of the map take precedence over
+// them, so `m.keys` reads the key "keys" if m has one.
+var mapMethods map[string]method
+
+func init() {
+	arrayMethods = map[string]method{
+		"map":    {1, 1, arrayMap},
+		"filter": {1, 1, arrayFilter},
+		"reduce": {1, 2, arrayReduce},
+		"find":   {1, 1, arrayFind},
+		"some":   {1, 1, arraySome},
+		"every":  {1, 1, arrayEvery},
+		"includes": {1, 1, func(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+			return e.indexOf(receiver, args[0]) >= 0, nil
+		}},
+		"indexOf": {1, 1, func(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+			return e.integer(e.indexOf(receiver, args[0])), nil
+		}},
+		"join":    {0, 1, arrayJoin},
+		"slice":   {0, 2, arraySlice},
+		"sort":    {0, 1, arraySort},
+		"reverse": {0, 0, arrayReverse},
+		"unique":  {0, 0, arrayUnique},
+		"flat":    {0, 1, arrayFlat},
+		"sum":     {0, 1, arraySum},
+		"min": {0, 1, func(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+			return e.extreme(ctx, receiver, args, -1)
+		}},
+		"max": {0, 1, func(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+			return e.extreme(ctx, receiver, args, 1)
+		}},
+		"groupBy": {1, 1, arrayGroupBy},
+	}
+	mapMethods = map[string]method{
+		"keys":    {0, 0, mapKeys},
+		"values":  {0, 0, mapValues},
+		"entries": {0, 0, mapEntries},
+		"has": {1, 1, func(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+			return e.contains(receiver.Interface(), args[0])
+		}},
+		"merge": {0, -1, mapMerge},
+		"pick":  {0, -1, mapPick},
+	}
+}
+
+// bindMethod returns the method called name of receiver as a function that
+// can be called from an expression.
+func (e *Evaluator) bindMethod(receiver reflect.Value, name string, m method) func(context.Context, ...interface{}) (interface{}, error) {
+	return func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		if len(args) < m.min || (m.max >= 0 && len(args) > m.max) {
+			expected := fmt.Sprintf("%d", m.min)
+			if m.max < 0 {
+				expected = fmt.Sprintf("at least %d", m.min)
+			} else if m.max != m.min {
+				expected = fmt.Sprintf("%d to %d", m.min, m.max)
+			}
+			return nil, NewEvaluationError("method '%s' expects %s arguments, got %d", name, expected, len(args))
+		}
+		return m.call(e, ctx, receiver, args)
+	}
+}
+
+// invoke calls fn, a closure or a Go function, with args. Go functions are
+// passed only as many arguments as they take.
+func (e *Evaluator) invoke(ctx context.Context, fn interface{}, args ...interface{}) (interface{}, error) {
+	if ctx.Err() != nil {
+		return nil, EvaluationCancelledErrror
+	}
+	if closure, ok := fn.(*Closure); ok {
+		return closure.Call(ctx, args...)
+	}
+	callee, err := e.function(fn, "callback")
+	if err != nil {
+		return nil, err
+	}
+	if !callee.Type().IsVariadic() {
+		take := callee.Type().NumIn()
+		if takesContext(callee.Type()) {
+			take--
+		}
+		if take < len(args) {
+			args = args[:take]
+		}
+	}
+	return e.call(ctx, callee, "callback", args)
+}
+
+func elements(receiver reflect.Value) []interface{} {
+	values := make([]interface{}, receiver.Len())
+	for i := range values {
+		values[i] = receiver.Index(i).Interface()
+	}
+	return values
+}
+
+func arrayMap(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	mapped := make([]interface{}, receiver.Len())
+	for i, item := range elements(receiver) {
+		value, err := e.invoke(ctx, args[0], item, e.integer(i))
+		if err != nil {
+			return nil, err
+		}
+		mapped[i] = value
+	}
+	return mapped, nil
+}
+
+func arrayFilter(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	kept := make([]interface{}, 0)
+	for i, item := range elements(receiver) {
+		keep, err := e.invoke(ctx, args[0], item, e.integer(i))
+		if err != nil {
+			return nil, err
+		}
+		if e.isTruthy(keep) {
+			kept = append(kept, item)
+		}
+	}
+	return kept, nil
+}
+
+// arrayReduce folds the array with a function of the accumulator, the item
+// and its index. Without an initial value, the first item is used.
+func arrayReduce(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	items := elements(receiver)
+	var accumulator interface{}
+	start := 0
+	if len(args) == 2 {
+		accumulator = args[1]
+	} else if len(items) == 0 {
+		return nil, fmt.Errorf("cannot reduce an empty array without an initial value")
+	} else {
+		accumulator, start = items[0], 1
+	}
+	for i := start; i < len(items); i++ {
+		var err error
+		if accumulator, err = e.invoke(ctx, args[0], accumulator, items[i], e.integer(i)); err != nil {
+			return nil, err
+		}
+	}
+	return accumulator, nil
+}
+
+// search returns the index of the first item for which fn is truthy, or -1.
+func (e *Evaluator) search(ctx context.Context, receiver reflect.Value, fn interface{}) (int, error) {
+	for i, item := range elements(receiver) {
+		found, err := e.invoke(ctx, fn, item, e.integer(i))
+		if err != nil {
+			return -1, err
+		}
+		if e.isTruthy(found) {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+func arrayFind(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	index, err := e.search(ctx, receiver, args[0])
+	if err != nil || index < 0 {
+		return nil, err
+	}
+	return receiver.Index(index).Interface(), nil
+}
+
+func arraySome(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	index, err := e.search(ctx, receiver, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return index >= 0, nil
+}
+
+func arrayEvery(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	for i, item := range elements(receiver) {
+		holds, err := e.invoke(ctx, args[0], item, e.integer(i))
+		if err != nil {
+			return nil, err
+		}
+		if !e.isTruthy(holds) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// indexOf returns the index of the first item equal to value, or -1.
+func (e *Evaluator) indexOf(receiver reflect.Value, value interface{}) int {
+	for i, item := range elements(receiver) {
+		if e.isEqual(item, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+func arrayJoin(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	separator := ","
+	if len(args) == 1 {
+		var ok bool
+		if separator, ok = args[0].(string); !ok {
+			return nil, fmt.Errorf("cannot join with a separator of type %T", args[0])
+		}
+	}
+	parts := make([]string, receiver.Len())
+	for i, item := range elements(receiver) {
+		if item != nil {
+			parts[i] = stringify(item)
+		}
+	}
+	joined := strings.Join(parts, separator)
+	return joined, e.limits.checkString(joined)
+}
+
+// arraySlice returns the items from start up to end, excluded. Negative
+// indexes count from the end of the array, as in javascript.
+func arraySlice(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	length := receiver.Len()
+	bounds := []int{0, length}
+	for i, arg := range args {
+		bound, ok := toInt(arg)
+		if !ok {
+			return nil, fmt.Errorf("index '%v' is not an integer", arg)
+		}
+		if bound < 0 {
+			bound += length
+		}
+		if bound < 0 {
+			bound = 0
+		} else if bound > length {
+			bound = length
+		}
+		bounds[i] = bound
+	}
+	if bounds[1] < bounds[0] {
+		bounds[1] = bounds[0]
+	}
+	return elements(receiver)[bounds[0]:bounds[1]], nil
+}
+
+// order compares two values the way sort, min and max do by default:
+// numbers by value, strings lexically and times chronologically.
+func (e *Evaluator) order(a, b interface{}) (int, error) {
+	if isNumber(a) && isNumber(b) {
+		cmp, _ := e.compareNumbers(a, b)
+		return cmp, nil
+	}
+	if cmp, ok := compareTimes(a, b); ok {
+		return cmp, nil
+	}
+	if l, ok := a.(string); ok {
+		if r, ok := b.(string); ok {
+			return strings.Compare(l, r), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+// comparator returns the order of two items: the default one, or the one
+// given by a function that returns a negative number if a comes before b,
+// a positive number if it comes after and 0 if their order does not matter.
+func (e *Evaluator) comparator(ctx context.Context, args []interface{}) func(a, b interface{}) (int, error) {
+	if len(args) == 0 {
+		return e.order
+	}
+	return func(a, b interface{}) (int, error) {
+		value, err := e.invoke(ctx, args[0], a, b)
+		if err != nil {
+			return 0, err
+		}
+		if !isNumber(value) {
+			return 0, fmt.Errorf("comparator returned %v, which is not a number", value)
+		}
+		cmp, _ := e.compareNumbers(value, 0)
+		return cmp, nil
+	}
+}
+
+func arraySort(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	sorted := elements(receiver)
+	compare := e.comparator(ctx, args)
+	var err error
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var cmp int
+		cmp, err = compare(sorted[i], sorted[j])
+		return cmp < 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sorted, nil
+}
+
+func arrayReverse(_ *Evaluator, _ context.Context, receiver reflect.Value, _ []interface{}) (interface{}, error) {
+	items := elements(receiver)
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
+
+func arrayUnique(e *Evaluator, _ context.Context, receiver reflect.Value, _ []interface{}) (interface{}, error) {
+	unique := make([]interface{}, 0, receiver.Len())
+	for _, item := range elements(receiver) {
+		if e.indexOf(reflect.ValueOf(unique), item) < 0 {
+			unique = append(unique, item)
+		}
+	}
+	return unique, nil
+}
+
+// arrayFlat flattens nested arrays up to a depth, 1 by default.
+func arrayFlat(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	depth := 1
+	if len(args) == 1 {
+		var ok bool
+		if depth, ok = toInt(args[0]); !ok {
+			return nil, fmt.Errorf("depth '%v' is not an integer", args[0])
+		}
+	}
+	return flatten(make([]interface{}, 0, receiver.Len()), receiver, depth), nil
+}
+
+func flatten(flat []interface{}, receiver reflect.Value, depth int) []interface{} {
+	for _, item := range elements(receiver) {
+		value := reflect.ValueOf(item)
+		if depth > 0 && (value.Kind() == reflect.Slice || value.Kind() == reflect.Array) {
+			flat = flatten(flat, value, depth-1)
+		} else {
+			flat = append(flat, item)
+		}
+	}
+	return flat
+}
+
+// arraySum adds the items, or what a function returns for each of them.
+func arraySum(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	sum := e.integer(0)
+	for i, item := range elements(receiver) {
+		var err error
+		if len(args) == 1 {
+			if item, err = e.invoke(ctx, args[0], item, e.integer(i)); err != nil {
+				return nil, err
+			}
+		}
+		if sum, err = e.add(sum, item); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// extreme returns the smallest item if sign is -1, or the largest one if it
+// is 1, in the default order or in the order given by a comparator. It is
+// nil for an empty array.
+func (e *Evaluator) extreme(ctx context.Context, receiver reflect.Value, args []interface{}, sign int) (interface{}, error) {
+	compare := e.comparator(ctx, args)
+	var extreme interface{}
+	for i, item := range elements(receiver) {
+		if i == 0 {
+			extreme = item
+			continue
+		}
+		cmp, err := compare(item, extreme)
+		if err != nil {
+			return nil, err
+		}
+		if cmp*sign > 0 {
+			extreme = item
+		}
+	}
+	return extreme, nil
+}
+
+// arrayGroupBy groups the items by what a function returns for them.
+func arrayGroupBy(e *Evaluator, ctx context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	groups := make(map[string]interface{})
+	for i, item := range elements(receiver) {
+		key, err := e.invoke(ctx, args[0], item, e.integer(i))
+		if err != nil {
+			return nil, err
+		}
+		name := stringify(key)
+		group, _ := groups[name].([]interface{})
+		groups[name] = append(group, item)
+	}
+	return groups, nil
+}
+
+// sortedKeys returns the keys of a map in their default order, or in the
+// order of their string form if they cannot be compared.
+func (e *Evaluator) sortedKeys(receiver reflect.Value) []reflect.Value {
+	keys := receiver.MapKeys()
+	var err error
+	sort.SliceStable(keys, func(i, j int) bool {
+		var cmp int
+		if err == nil {
+			cmp, err = e.order(keys[i].Interface(), keys[j].Interface())
+		}
+		return cmp < 0
+	})
+	if err != nil {
+		sort.SliceStable(keys, func(i, j int) bool {
+			return stringify(keys[i].Interface()) < stringify(keys[j].Interface())
+		})
+	}
+	return keys
+}
+
+func mapKeys(e *Evaluator, _ context.Context, receiver reflect.Value, _ []interface{}) (interface{}, error) {
+	keys := make([]interface{}, 0, receiver.Len())
+	for _, key := range e.sortedKeys(receiver) {
+		keys = append(keys, key.Interface())
+	}
+	return keys, nil
+}
+
+func mapValues(e *Evaluator, _ context.Context, receiver reflect.Value, _ []interface{}) (interface{}, error) {
+	values := make([]interface{}, 0, receiver.Len())
+	for _, key := range e.sortedKeys(receiver) {
+		values = append(values, receiver.MapIndex(key).Interface())
+	}
+	return values, nil
+}
+
+// mapEntries returns the [key, value] pairs of a map.
+func mapEntries(e *Evaluator, _ context.Context, receiver reflect.Value, _ []interface{}) (interface{}, error) {
+	entries := make([]interface{}, 0, receiver.Len())
+	for _, key := range e.sortedKeys(receiver) {
+		entries = append(entries, []interface{}{key.Interface(), receiver.MapIndex(key).Interface()})
+	}
+	return entries, nil
+}
+
+// mapMerge returns a new map with the entries of the map and of the maps
+// passed to it, the last ones taking precedence.
+func mapMerge(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	merged := make(map[string]interface{}, receiver.Len())
+	for _, m := range append([]interface{}{receiver.Interface()}, args...) {
+		value := reflect.ValueOf(m)
+		if value.Kind() != reflect.Map {
+			return nil, fmt.Errorf("cannot merge %T into a map", m)
+		}
+		iter := value.MapRange()
+		for iter.Next() {
+			merged[stringify(iter.Key().Interface())] = iter.Value().Interface()
+		}
+	}
+	return merged, nil
+}
+
+// mapPick returns a new map with only the given keys of the map. Keys are
+// passed as arguments or as a single array.
+func mapPick(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	if len(args) == 1 {
+		if keys := reflect.ValueOf(args[0]); keys.Kind() == reflect.Slice || keys.Kind() == reflect.Array {
+			args = elements(keys)
+		}
+	}
+	picked := make(map[string]interface{}, len(args))
+	for _, key := range args {
+		k, ok := mapKey(receiver, key)
+		if !ok {
+			continue
+		}
+		if value := receiver.MapIndex(k); value.IsValid() {
+			picked[stringify(key)] = value.Interface()
+		}
+	}
+	return picked, nil
+}
+
+// mapKey converts key to the key type of the map m. ok is false if it
+// cannot be converted without loss, so that 1.5 is not a key of a
+// map[int]string.
+func mapKey(m reflect.Value, key interface{}) (reflect.Value, bool) {
+	k := reflect.ValueOf(key)
+	if !k.IsValid() || !k.Type().Comparable() {
+		return k, false
+	}
+	keyType := m.Type().Key()
+	if k.Type().AssignableTo(keyType) {
+		return k, true
+	}
+	if k.Type().ConvertibleTo(keyType) && (k.Kind() == reflect.String) == (keyType.Kind() == reflect.String) {
+		converted := k.Convert(keyType)
+		if converted.Convert(k.Type()).Interface() != key {
+			return k, false
+		}
+		return converted, true
+	}
+	return k, false
+}
@@ -0,0 +1,490 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type (
+	// Program is an expression compiled by Evaluator.Compile. Its nodes are
+	// lowered into Go closures once, so running it skips the dispatch and
+	// allocations of walking the tree. A Program is safe for concurrent use.
+	Program struct {
+		evaluator *Evaluator
+		run       compiled
+	}
+
+	// compiled evaluates a node of a Program.
+	compiled func(*frame) (interface{}, error)
+
+	// frame holds the state of a single run of a Program.
+	frame struct {
+		inlineRun
+		usage
+		scope *Scope
+		// env is the scope of the variables passed to Run, kept in the
+		// frame to save an allocation.
+		env Scope
+		// ticks counts the calls to cancelled.
+		ticks int
+	}
+
+	compiler struct {
+		evaluator *Evaluator
+		// counted is set when steps and depth are limited, which costs a
+		// wrapper around every node.
+		counted bool
+	}
+
+	absentValue struct{}
+)
+
+// absent is the value of an optional chain that short-circuited. It never
+// escapes the chain: reading the chain as a whole gives nil.
+var absent interface{} = absentValue{}
+
+// Compile lowers expr into a Program that evaluates it like Evaluate does.
+// Programs that contain a parse error cannot be compiled.
+func (i *Evaluator) Compile(expr Expr) (*Program, error) {
+	err := Walk(expr, func(e Expr) error {
+		if parseErr, ok := e.(*ParseError); ok {
+			return fmt.Errorf("parse error: %w", parseErr)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{evaluator: i, counted: i.limits.MaxSteps > 0 || i.limits.MaxDepth > 0}
+	return &Program{evaluator: i, run: c.compile(expr)}, nil
+}
+
+// Run evaluates the program. Variables are looked up in env first, then in
+// the members of the evaluator that compiled the program. Unlike Evaluate,
+// Run evaluates on the calling goroutine: functions that ignore their
+// context can make it overrun the timeout, which is then reported once
+// they return.
+func (p *Program) Run(ctx context.Context, env map[string]interface{}) (interface{}, error) {
+	f := p.newFrame(ctx)
+	f.env.vars = env
+	f.scope = &f.env
+	return p.execute(f)
+}
+
+// RunIn evaluates the program with the variables of scope and its parents
+// layered over the members of the evaluator. scope can be nil.
+func (p *Program) RunIn(ctx context.Context, scope *Scope) (interface{}, error) {
+	f := p.newFrame(ctx)
+	f.scope = scope
+	return p.execute(f)
+}
+
+func (p *Program) newFrame(ctx context.Context) *frame {
+	return &frame{inlineRun: inlineRun{parent: ctx, deadline: time.Now().Add(p.evaluator.timeout)}}
+}
+
+func (p *Program) execute(f *frame) (value interface{}, err error) {
+	p.evaluator.lock.RLock()
+	defer p.evaluator.lock.RUnlock()
+	defer func() {
+		f.stop()
+		if r := recover(); r != nil {
+			value, err = nil, NewEvaluationError("%v", r)
+		}
+	}()
+
+	value, err = p.run(f)
+	if ctxErr := f.err(); ctxErr != nil {
+		return nil, NewEvaluationError("evaluation canceled: %s", ctxErr.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// cancelled reports whether the run was cancelled or timed out. It checks
+// the clock every clockInterval calls, in the loops that a program can
+// spend long in: operators and the bodies of lambdas.
+func (f *frame) cancelled() bool {
+	f.ticks++
+	return f.ticks%clockInterval == 0 && f.err() != nil
+}
+
+func (c *compiler) compile(expr Expr) compiled {
+	switch expr.(type) {
+	case *Variable, *Get, *Index, *Call, *Optional:
+		// Counted by chain.
+		return c.node(expr)
+	}
+	return c.count(expr, c.node(expr))
+}
+
+// count wraps the closure compiled for expr so that it counts against
+// Limits.MaxSteps and Limits.MaxDepth.
+func (c *compiler) count(expr Expr, compiled compiled) compiled {
+	if !c.counted {
+		return compiled
+	}
+	span := expr.Span()
+	return func(f *frame) (interface{}, error) {
+		if err := f.enter(c.evaluator.limits); err != nil {
+			return nil, locate(err, span)
+		}
+		value, err := compiled(f)
+		f.leave()
+		return value, err
+	}
+}
+
+func (c *compiler) node(expr Expr) compiled {
+	switch e := expr.(type) {
+	case *Literal:
+		return c.literal(e)
+	case *Grouping:
+		return c.compile(e.expression)
+	case *Template:
+		return c.template(e)
+	case *Unary:
+		return c.unary(e)
+	case *Binary:
+		return c.binary(e)
+	case *Comparison:
+		return c.comparison(e)
+	case *Ternary:
+		return c.ternary(e)
+	case *Variable, *Get, *Index, *Call, *Optional:
+		return c.lookup(e, false)
+	case *Array:
+		return c.array(e)
+	case *Map:
+		return c.mapLiteral(e)
+	case *Lambda:
+		return c.lambda(e)
+	case *MapEntry:
+		entry := c.mapEntry(e)
+		return func(f *frame) (interface{}, error) {
+			key, value, err := entry(f)
+			if err != nil {
+				return nil, err
+			}
+			return [2]interface{}{key, value}, nil
+		}
+	}
+	panic(fmt.Errorf("cannot compile expression of type %T", expr))
+}
+
+func (c *compiler) literal(expr *Literal) compiled {
+	value := c.evaluator.literal(expr)
+	return func(*frame) (interface{}, error) {
+		return value, nil
+	}
+}
+
+func (c *compiler) template(expr *Template) compiled {
+	parts := c.all(expr.expressions)
+	span := expr.Span()
+	if len(parts) == 1 {
+		part := parts[0]
+		return func(f *frame) (interface{}, error) {
+			value, err := part(f)
+			if err != nil {
+				return nil, err
+			}
+			return value, locate(c.evaluator.limits.checkOutput(value), span)
+		}
+	}
+	return func(f *frame) (interface{}, error) {
+		evaluations, err := run(f, parts)
+		if err != nil {
+			return nil, err
+		}
+		value, err := c.evaluator.join(evaluations)
+		return value, locate(err, span)
+	}
+}
+
+// lookup compiles a chain of lookups. In strict mode, lookups that are
+// lenient give nil instead of an UndefinedError when what they read does
+// not exist.
+func (c *compiler) lookup(expr Expr, lenient bool) compiled {
+	chain := c.chain(expr, lenient)
+	return func(f *frame) (interface{}, error) {
+		value, err := chain(f)
+		if value == absent {
+			value = nil
+		}
+		return value, err
+	}
+}
+
+func (c *compiler) variable(expr *Variable, lenient bool) compiled {
+	name, span := expr.name.lexeme, expr.Span()
+	return func(f *frame) (interface{}, error) {
+		value, ok := c.evaluator.lookup(f.scope, name)
+		if !ok && c.evaluator.strict && !lenient {
+			return nil, locate(&UndefinedError{Path: name}, span)
+		}
+		return value, nil
+	}
+}
+
+func (c *compiler) unary(expr *Unary) compiled {
+	right := c.compile(expr.right)
+	operator, span := expr.operator.tokenType, expr.Span()
+	return func(f *frame) (interface{}, error) {
+		value, err := right(f)
+		if err != nil {
+			return nil, err
+		}
+		value, err = c.evaluator.unary(operator, value)
+		return value, locate(err, span)
+	}
+}
+
+func (c *compiler) binary(expr *Binary) compiled {
+	operator, span := expr.operator.tokenType, expr.Span()
+	var left compiled
+	if (operator == NULLCOALESCING || operator == FALSY_COALESCING) && isLookup(expr.left) {
+		left = c.lookup(expr.left, true)
+	} else {
+		left = c.compile(expr.left)
+	}
+	right := c.compile(expr.right)
+
+	// shortCircuit reports whether the value of the left operand is the
+	// value of the whole expression.
+	var shortCircuit func(interface{}) bool
+	switch operator {
+	case AND:
+		shortCircuit = func(left interface{}) bool { return !c.evaluator.isTruthy(left) }
+	case OR, FALSY_COALESCING:
+		shortCircuit = c.evaluator.isTruthy
+	case NULLCOALESCING:
+		shortCircuit = func(left interface{}) bool { return left != nil }
+	}
+
+	return func(f *frame) (interface{}, error) {
+		if f.cancelled() {
+			return nil, EvaluationCancelledErrror
+		}
+		l, err := left(f)
+		if err != nil {
+			return nil, err
+		}
+		if shortCircuit != nil && shortCircuit(l) {
+			if operator == AND || operator == OR {
+				return c.evaluator.isTruthy(l), nil
+			}
+			return l, nil
+		}
+		r, err := right(f)
+		if err != nil {
+			return nil, err
+		}
+		value, err := c.evaluator.binary(operator, l, r)
+		return value, locate(err, span)
+	}
+}
+
+func (c *compiler) comparison(expr *Comparison) compiled {
+	operands := c.all(expr.operands)
+	operators := make([]TokenType, len(expr.operators))
+	for i, operator := range expr.operators {
+		operators[i] = operator.tokenType
+	}
+	span := expr.Span()
+	return func(f *frame) (interface{}, error) {
+		left, err := operands[0](f)
+		if err != nil {
+			return nil, err
+		}
+		for i, operator := range operators {
+			right, err := operands[i+1](f)
+			if err != nil {
+				return nil, err
+			}
+			holds, err := c.evaluator.compare(operator, left, right)
+			if err != nil || !c.evaluator.isTruthy(holds) {
+				return holds, locate(err, span)
+			}
+			left = right
+		}
+		return true, nil
+	}
+}
+
+func (c *compiler) ternary(expr *Ternary) compiled {
+	condition := c.compile(expr.condition)
+	trueExpr, falseExpr := c.compile(expr.trueExpr), c.compile(expr.falseExpr)
+	return func(f *frame) (interface{}, error) {
+		value, err := condition(f)
+		if err != nil {
+			return nil, err
+		}
+		if c.evaluator.isTruthy(value) {
+			return trueExpr(f)
+		}
+		return falseExpr(f)
+	}
+}
+
+// chain compiles a link of a chain of lookups and calls. Unlike compile,
+// the closure it returns gives absent when an optional chain
+// short-circuits, so that the rest of the chain is skipped.
+func (c *compiler) chain(expr Expr, lenient bool) compiled {
+	switch expr.(type) {
+	case *Grouping, *Variable, *Optional, *Get, *Index, *Call:
+		return c.count(expr, c.link(expr, lenient))
+	}
+	return c.compile(expr)
+}
+
+func (c *compiler) link(expr Expr, lenient bool) compiled {
+	switch e := expr.(type) {
+	case *Grouping:
+		return c.chain(e.expression, lenient)
+	case *Variable:
+		return c.variable(e, lenient)
+	case *Optional:
+		left := c.chain(e.left, true)
+		return func(f *frame) (interface{}, error) {
+			value, err := left(f)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return absent, nil
+			}
+			return value, nil
+		}
+	case *Get:
+		object := c.chain(e.object, lenient)
+		name := e.name.lexeme
+		lenient = lenient || isOptional(e.object)
+		return func(f *frame) (interface{}, error) {
+			obj, err := object(f)
+			if err != nil || obj == absent {
+				return obj, err
+			}
+			value, err := c.evaluator.property(obj, name)
+			return value, locate(c.evaluator.undefined(err, e, lenient), e.Span())
+		}
+	case *Index:
+		object, index := c.chain(e.object, lenient), c.compile(e.index)
+		lenient = lenient || isOptional(e.object)
+		return func(f *frame) (interface{}, error) {
+			obj, err := object(f)
+			if err != nil || obj == absent {
+				return obj, err
+			}
+			key, err := index(f)
+			if err != nil {
+				return nil, err
+			}
+			value, err := c.evaluator.index(obj, key)
+			return value, locate(c.evaluator.undefined(err, e, lenient), e.Span())
+		}
+	case *Call:
+		return c.call(e)
+	}
+	return c.compile(expr)
+}
+
+func (c *compiler) call(expr *Call) compiled {
+	callee, args := c.chain(expr.callee, false), c.all(expr.arguments)
+	name, span := identifyCallee(expr), expr.Span()
+	return func(f *frame) (interface{}, error) {
+		value, err := callee(f)
+		if err != nil || value == absent {
+			return value, err
+		}
+		fn, err := c.evaluator.function(value, name)
+		if err != nil {
+			return nil, locate(err, span)
+		}
+		values, err := run(f, args)
+		if err != nil {
+			return nil, err
+		}
+		if f.err() != nil {
+			return nil, EvaluationCancelledErrror
+		}
+		ctx := f.parent
+		if takesContext(fn.Type()) {
+			ctx = f.context()
+		}
+		value, err = c.evaluator.call(ctx, fn, name, values)
+		return value, locate(err, span)
+	}
+}
+
+func (c *compiler) array(expr *Array) compiled {
+	values := c.all(expr.values)
+	span := expr.Span()
+	return func(f *frame) (interface{}, error) {
+		if err := c.evaluator.limits.checkCollection(len(values)); err != nil {
+			return nil, locate(err, span)
+		}
+		return run(f, values)
+	}
+}
+
+func (c *compiler) mapLiteral(expr *Map) compiled {
+	entries := make([]func(*frame) (interface{}, interface{}, error), len(expr.entries))
+	for i, entry := range expr.entries {
+		entries[i] = c.mapEntry(entry)
+	}
+	span := expr.Span()
+	return func(f *frame) (interface{}, error) {
+		if err := c.evaluator.limits.checkCollection(len(entries)); err != nil {
+			return nil, locate(err, span)
+		}
+		m := make(map[string]interface{}, len(entries))
+		for _, entry := range entries {
+			key, value, err := entry(f)
+			if err != nil {
+				return nil, err
+			}
+			m[stringify(key)] = value
+		}
+		return m, nil
+	}
+}
+
+func (c *compiler) mapEntry(expr *MapEntry) func(*frame) (interface{}, interface{}, error) {
+	key, value := c.compile(expr.key), c.compile(expr.value)
+	return func(f *frame) (interface{}, interface{}, error) {
+		k, err := key(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := value(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		return k, v, nil
+	}
+}
+
+func (c *compiler) all(exprs []Expr) []compiled {
+	compiled := make([]compiled, len(exprs))
+	for i, expr := range exprs {
+		compiled[i] = c.compile(expr)
+	}
+	return compiled
+}
+
+// run evaluates each of exprs in order.
+func run(f *frame, exprs []compiled) ([]interface{}, error) {
+	values := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		value, err := expr(f)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
@@ -33,16 +33,24 @@ const (
 	TEMPLATE_RIGHT_BRACE
 	OPTIONALCHAIN
 	NULLCOALESCING
+	FALSY_COALESCING
+	STAR_STAR
+	SLASH_SLASH
+	ARROW
 	AND
 	OR
 	// Literals.
 	IDENTIFIER
 	STRING
 	NUMBER
+	DURATION
 	TEXT
 	// Keywords.
 	_keywordStart
 	FALSE
 	TRUE
 	NIL
+	NOT
+	IN
+	NOT_IN
 )
@@ -0,0 +1,206 @@
+package parser
+
+import "reflect"
+
+// Equaler is implemented by Go types that define their own equality. `==`,
+// `!=`, `in` and the methods of arrays that look for elements call Equal on
+// the left operand if it is an Equaler, or else on the right operand.
+type Equaler interface {
+	Equal(other interface{}) bool
+}
+
+// isEqual reports whether a and b are deeply equal. Numbers are equal if
+// they have the same value whatever their type, times if they are the same
+// instant, arrays and slices if their elements are equal, maps if they
+// have equal values for equal keys, and structs of the same type if their
+// fields are equal. Pointers are compared by the values they point to, so a
+// pointer to a struct equals a copy of the struct.
+func (e *Evaluator) isEqual(a, b interface{}) bool {
+	var visits visits
+	return e.equal(a, b, &visits)
+}
+
+// visit is a comparison of two slices, maps or pointers.
+type visit struct {
+	left, right         uintptr
+	leftType, rightType reflect.Type
+}
+
+// visits records the comparisons of slices, maps and pointers in progress.
+// Comparing them again while they are, as cyclic values do, reports them as
+// equal, as in reflect.DeepEqual.
+type visits struct {
+	seen map[visit]bool
+}
+
+// enter reports whether left and right are already being compared, and
+// records that they are if not.
+func (v *visits) enter(left, right reflect.Value) (visit, bool) {
+	key := visit{left.Pointer(), right.Pointer(), left.Type(), right.Type()}
+	if v.seen[key] {
+		return key, true
+	}
+	if v.seen == nil {
+		v.seen = make(map[visit]bool)
+	}
+	v.seen[key] = true
+	return key, false
+}
+
+// leave returns equal, the result of the comparison recorded as key. A
+// comparison that failed is forgotten, so that comparing the same values
+// again does not report them as equal.
+func (v *visits) leave(key visit, equal bool) bool {
+	if !equal {
+		delete(v.seen, key)
+	}
+	return equal
+}
+
+func (e *Evaluator) equal(a, b interface{}, visits *visits) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if equaler, ok := a.(Equaler); ok {
+		return equaler.Equal(b)
+	}
+	if equaler, ok := b.(Equaler); ok {
+		return equaler.Equal(a)
+	}
+	if isNumber(a) && isNumber(b) {
+		cmp, ok := e.compareNumbers(a, b)
+		return ok && cmp == 0
+	}
+	if cmp, ok := compareTimes(a, b); ok {
+		return cmp == 0
+	}
+	left, right := reflect.ValueOf(a), reflect.ValueOf(b)
+	if (left.Kind() == reflect.Ptr) != (right.Kind() == reflect.Ptr) {
+		if left.Kind() == reflect.Ptr && !left.IsNil() {
+			return e.equal(left.Elem().Interface(), b, visits)
+		}
+		if right.Kind() == reflect.Ptr && !right.IsNil() {
+			return e.equal(a, right.Elem().Interface(), visits)
+		}
+		return false
+	}
+	switch left.Kind() {
+	case reflect.Slice, reflect.Array:
+		if right.Kind() != reflect.Slice && right.Kind() != reflect.Array {
+			return false
+		}
+		if left.Len() != right.Len() {
+			return false
+		}
+		if left.Kind() != reflect.Slice || right.Kind() != reflect.Slice {
+			return e.equalElements(left, right, visits)
+		}
+		key, seen := visits.enter(left, right)
+		if seen {
+			return true
+		}
+		return visits.leave(key, e.equalElements(left, right, visits))
+	case reflect.Map:
+		if right.Kind() != reflect.Map || left.Len() != right.Len() {
+			return false
+		}
+		key, seen := visits.enter(left, right)
+		if seen {
+			return true
+		}
+		return visits.leave(key, e.equalMaps(left, right, visits))
+	case reflect.Ptr:
+		if left.Pointer() == right.Pointer() {
+			return true
+		}
+		if left.IsNil() || right.IsNil() {
+			return false
+		}
+		key, seen := visits.enter(left, right)
+		if seen {
+			return true
+		}
+		return visits.leave(key, e.equal(left.Elem().Interface(), right.Elem().Interface(), visits))
+	case reflect.Struct:
+		if left.Type() != right.Type() {
+			return false
+		}
+		return e.equalStructs(left, right, visits)
+	}
+	if left.Type().Comparable() && right.Type().Comparable() {
+		return a == b
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// equalElements compares the elements of two arrays or slices of the same
+// length.
+func (e *Evaluator) equalElements(left, right reflect.Value, visits *visits) bool {
+	for i := 0; i < left.Len(); i++ {
+		if !e.equal(left.Index(i).Interface(), right.Index(i).Interface(), visits) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalMaps compares the values of two maps of the same length for equal
+// keys.
+func (e *Evaluator) equalMaps(left, right reflect.Value, visits *visits) bool {
+	iter := left.MapRange()
+	for iter.Next() {
+		value, ok := e.mapValue(right, iter.Key(), visits)
+		if !ok || !e.equal(iter.Value().Interface(), value.Interface(), visits) {
+			return false
+		}
+	}
+	return true
+}
+
+// mapValue returns the value of m for a key equal to key, which need not
+// be of the type of the keys of m.
+func (e *Evaluator) mapValue(m, key reflect.Value, visits *visits) (reflect.Value, bool) {
+	if key.Type().AssignableTo(m.Type().Key()) {
+		value := m.MapIndex(key)
+		return value, value.IsValid()
+	}
+	iter := m.MapRange()
+	for iter.Next() {
+		if e.equal(key.Interface(), iter.Key().Interface(), visits) {
+			return iter.Value(), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// equalStructs compares the exported fields of two structs of the same type
+// with equal, and their unexported fields with reflect.DeepEqual.
+func (e *Evaluator) equalStructs(left, right reflect.Value, visits *visits) bool {
+	t := left.Type()
+	exported := false
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			continue
+		}
+		exported = true
+		if !e.equal(left.Field(i).Interface(), right.Field(i).Interface(), visits) {
+			return false
+		}
+	}
+	if !exported {
+		return reflect.DeepEqual(left.Interface(), right.Interface())
+	}
+	// Compare copies whose exported fields are zeroed, since unexported
+	// fields cannot be read through reflection.
+	hidden := func(v reflect.Value) interface{} {
+		masked := reflect.New(t).Elem()
+		masked.Set(v)
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				masked.Field(i).Set(reflect.Zero(t.Field(i).Type))
+			}
+		}
+		return masked.Interface()
+	}
+	return reflect.DeepEqual(hidden(left), hidden(right))
+}
@@ -2,11 +2,15 @@ package parser
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"math/big"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,6 +32,23 @@ type Dummy struct {
 	Exposed string
 }
 
+type Profile struct {
+	Timezone string `json:"time_zone"`
+}
+
+type User struct {
+	*Profile
+	FirstName string `json:"first_name"`
+	LastName  string `xpress:"surname" json:"last_name"`
+	Email     string `json:"-"`
+}
+
+type Account struct {
+	Name     string
+	Password string `xpress:"-"`
+	balance  float64
+}
+
 var cases = []SuccessCases{
 	{template: "Just raw text", expect: "Just raw text"},
 	{template: "@{{ 123 * (45.67) }}", expect: float64(123 * 45.67)},
@@ -44,6 +65,17 @@ var cases = []SuccessCases{
 	{template: "@{{ 4 + -4 }}", expect: float64(0)},
 	{template: "@{{ 10 - 4 }}", expect: float64(6)},
 	{template: "@{{ 8 / 4 }}", expect: float64(2)},
+	{template: "@{{ 7 % 3 }}", expect: float64(1)},
+	{template: "@{{ -7 % 3 }}", expect: float64(-1)},
+	{template: "@{{ 7.5 % 2 }}", expect: float64(1.5)},
+	{template: "@{{ 1 + 7 % 3 * 2 }}", expect: float64(3)},
+	{template: "@{{ 7 // 2 }}", expect: float64(3)},
+	{template: "@{{ -7 // 2 }}", expect: float64(-4)},
+	{template: "@{{ 2 ** 10 }}", expect: float64(1024)},
+	{template: "@{{ 2 ** 3 ** 2 }}", expect: float64(512)},
+	{template: "@{{ -2 ** 2 }}", expect: float64(-4)},
+	{template: "@{{ 2 ** -1 }}", expect: float64(0.5)},
+	{template: "@{{ 2 * 3 ** 2 }}", expect: float64(18)},
 	{template: "@{{ 5 > 4 }}", expect: true},
 	{template: "@{{ 3 > 4 }}", expect: false},
 	{template: "@{{ 5 < 4 }}", expect: false},
@@ -52,6 +84,9 @@ var cases = []SuccessCases{
 	{template: "@{{ 5 == 4 }}", expect: false},
 	{template: "@{{ 5 != 5 }}", expect: false},
 	{template: "@{{ 5 != 6 }}", expect: true},
+	{template: "@{{ true == 1 < 2 }}", expect: true},
+	{template: "@{{ 1 == 1 > 0 }}", expect: false},
+	{template: "@{{ 1 < 2 == 2 < 3 }}", expect: true},
 	{template: "@{{ 5 >= 4 }}", expect: true},
 	{template: "@{{ 5 >= 5 }}", expect: true},
 	{template: "@{{ 5 >= 6 }}", expect: false},
@@ -78,8 +113,34 @@ var cases = []SuccessCases{
 	{template: "@{{ true && true}}", expect: true},
 	{template: "@{{ true && false}}", expect: false},
 	{template: "@{{ false && false}}", expect: false},
-	{template: "@{{ false ?? 6}}", expect: float64(6)},
+	{template: "@{{ false ?? 6}}", expect: false},
+	{template: "@{{ 0 ?? 6}}", expect: float64(0)},
+	{template: `@{{ "" ?? 6}}`, expect: ""},
+	{template: "@{{ nil ?? 6}}", expect: float64(6)},
+	{template: "@{{ nil ?? nil ?? 6}}", expect: float64(6)},
+	{template: "@{{ someObject.nonexistent ?? 6}}", expect: float64(6)},
+	{template: "@{{ someObject.nonexistent?.deep.path ?? 6}}", expect: float64(6)},
+	{template: "@{{ false ?: 6}}", expect: float64(6)},
+	{template: "@{{ nil ?: 6}}", expect: float64(6)},
+	{template: "@{{ 5 ?: 6}}", expect: float64(5)},
 	{template: "@{{ 5 ?? dsfsd.fsdf.f}}", expect: float64(5)},
+	{template: "@{{ true and true }}", expect: true},
+	{template: "@{{ true and false }}", expect: false},
+	{template: "@{{ false or true }}", expect: true},
+	{template: "@{{ not true }}", expect: false},
+	{template: "@{{ not 1 > 2 }}", expect: true},
+	{template: "@{{ not false and not false }}", expect: true},
+	{template: "@{{ 4 > 5 or 5 == 5 and true }}", expect: true},
+	{template: "@{{ 2 in [1, 2, 3] }}", expect: true},
+	{template: "@{{ 4 in [1, 2, 3] }}", expect: false},
+	{template: "@{{ 4 not in [1, 2, 3] }}", expect: true},
+	{template: `@{{ "ell" in "hello" }}`, expect: true},
+	{template: `@{{ "key" in someObject }}`, expect: true},
+	{template: `@{{ "nope" not in someObject }}`, expect: true},
+	{template: "@{{ 65 in {A: 1} }} @{{ {A: 1}.has(65) }}", expect: "false false"},
+	{template: `@{{ not "key" in someObject }}`, expect: false},
+	{template: `@{{ 1 + 1 in [2] == true }}`, expect: true},
+	{template: `@{{ !1 in [false] }}`, expect: true},
 	{template: "@{{ true || true}}", expect: true},
 	{template: "@{{ true || false}}", expect: true},
 	{template: "@{{ false || false}}", expect: false},
@@ -97,6 +158,10 @@ var cases = []SuccessCases{
 	{template: `@{{ "a string" == "a different string"}}`, expect: false},
 	{template: `@{{ "a string" != "a string"}}`, expect: false},
 	{template: `@{{ "a string" != "a string"}}`, expect: false},
+	{template: `@{{ "a\nb\tc\\d" }}`, expect: "a\nb\tc\\d"},
+	{template: `@{{ 'it\'s' + "\"quoted\"" }}`, expect: `it's"quoted"`},
+	{template: `@{{ "\u00e9\x41\u{1F600}\uD83D\uDE00" }}`, expect: "éA😀😀"},
+	{template: `@{{ {"a\tb": 1} }}`, expect: map[string]interface{}{"a\tb": float64(1)}},
 	{template: `@{{[1, 2, true, "a"]}}`, expect: []interface{}{float64(1), float64(2), true, "a"}},
 	{template: `@{{[1, 2, true, "a"]}} `, expect: "[1 2 true a] "},
 	{template: `@{{ "a string" + " " + "Joined" }}`, expect: "a string Joined"},
@@ -163,9 +228,18 @@ var cases = []SuccessCases{
 }
 
 var errorCases = []ErrorCases{
-	{template: "@{{ 5 > }}", msg: "parse error: Error at position 8. Expect expression. got }}"},
-	{template: "@{{ 5 ", msg: "parse error: Error at position 6. Expect '}}' after expression. got unclosed action"},
-	{template: "@{{ 5 6 }}", msg: "parse error: Error at position 6. Expect '}}' after expression. got 6"},
+	{template: "@{{ 5 > }}", msg: "parse error: Error at line 1, column 9. Expect expression. got }}"},
+	{template: "@{{ 5 ", msg: "parse error: Error at line 1, column 7. Expect '}}' after expression. got unclosed action"},
+	{template: "@{{ 5 6 }}", msg: "parse error: Error at line 1, column 7. Expect '}}' after expression. got 6"},
+	{template: "@{{ 5 / 0 }}", msg: "cannot divide by zero"},
+	{template: "@{{ 5 % 0 }}", msg: "cannot divide by zero"},
+	{template: "@{{ 5 // 0 }}", msg: "cannot divide by zero"},
+	{template: "@{{ 0 ** -1 }}", msg: "cannot divide by zero"},
+	{template: "@{{ 'a' % 2 }}", msg: "cannot compute modulo of non-numbers"},
+	{template: "@{{ 0 < 5 <= 10 }}", msg: "cannot compare bool with float64"},
+	{template: "@{{ 1 in nil }}", msg: "cannot check membership in <nil>"},
+	{template: "@{{ 1 in 'abc' }}", msg: "cannot check if float64 is in string"},
+	{template: "@{{ 1 not 2 }}", msg: "Expect '}}' after expression. got not"},
 	{template: "@{{ nonexistentFunction() }}", msg: "cannot call non-function 'nonexistentFunction' of type <nil>"},
 	{template: "@{{ nonexistent['key'] }}", msg: "cannot index into nil"},
 	{template: "@{{ ['some', 'string', 'array'].wrong }}", msg: "property 'wrong' does not exist"},
@@ -174,7 +248,7 @@ var errorCases = []ErrorCases{
 	{template: "@{{ getDeepObject().deep.object.with.values[3] }}", msg: "index '3' is out of bounds"},
 	{template: "@{{ getDeepObject().deep.object.with.values[-1] }}", msg: "index '-1' is out of bounds"},
 	{template: "@{{ getDeepObject().nonexistent.key }}", msg: "cannot get property 'key' of nil"},
-	{template: "@{{ [1,2,3,4 }}", msg: "parse error: Error at position 13. Expect ']' after array expression. got }"},
+	{template: "@{{ [1,2,3,4 }}", msg: "parse error: Error at line 1, column 14. Expect ']' after array expression. got }"},
 	{template: "@{{ ([1,2,3,4] }} ", msg: "Expect ')' after expression. got }"},
 	{template: `@{{ {["some-key": "some-val"} }}`, msg: "Expect ']' after index expression. got :"},
 	{template: `@{{ {: "something"} }}`, msg: "Expect map key. got :"},
@@ -184,6 +258,11 @@ var errorCases = []ErrorCases{
 	{template: `@{{ someObj["index" }}`, msg: `Expect ']' after index expression. got }`},
 	{template: `@{{ someObj. }}`, msg: `Expect property name after '.'. got }`},
 	{template: `@{{ someObj ? "yes" "no" }}`, msg: `Expect ':' after true expression. got "no"`},
+	{template: `@{{ "a\qb" }}`, msg: `invalid escape sequence "\\q"`},
+	{template: `@{{ "\u12" }}`, msg: `invalid escape sequence "\\u12"`},
+	{template: `@{{ "\xZZ" }}`, msg: `invalid escape sequence "\\xZZ"`},
+	{template: `@{{ "\u{110000}" }}`, msg: `invalid escape sequence "\\u{110000}"`},
+	{template: `@{{ {'\k': 1} }}`, msg: `invalid escape sequence "\\k"`},
 	{template: `@{{ concat(6) }}`, msg: `argument '6' is not assignable to type 'string'`},
 	{template: `@{{ math.min(6, "5") }}`, msg: `argument '5' is not assignable to parameter 'float64'`},
 	{template: `@{{ math.min(6, 7,8) }}`, msg: `function 'min' expects 2 arguments, got 3`},
@@ -200,55 +279,1047 @@ func (d Dummy) StructReceiverMethod() string {
 	return "struct value"
 }
 
+func (a *Account) Balance() float64 {
+	return a.balance
+}
+
+func (a *Account) Withdraw(amount float64) float64 {
+	a.balance -= amount
+	return a.balance
+}
+
+// modes evaluates an expression either by walking the tree or by compiling
+// it first, so that the test tables cover both.
+var modes = []struct {
+	name     string
+	evaluate func(*Evaluator, Expr) (interface{}, error)
+}{
+	{name: "interpreted", evaluate: func(evaluator *Evaluator, ast Expr) (interface{}, error) {
+		return evaluator.Evaluate(context.TODO(), ast)
+	}},
+	{name: "synchronous", evaluate: func(evaluator *Evaluator, ast Expr) (interface{}, error) {
+		evaluator.SetSynchronous(true)
+		defer evaluator.SetSynchronous(false)
+		return evaluator.Evaluate(context.TODO(), ast)
+	}},
+	{name: "compiled", evaluate: func(evaluator *Evaluator, ast Expr) (interface{}, error) {
+		program, err := evaluator.Compile(ast)
+		if err != nil {
+			return nil, err
+		}
+		return program.Run(context.TODO(), nil)
+	}},
+}
+
+// runCases evaluates the templates of successes and failures with e in
+// every mode.
+func runCases(t *testing.T, e *Evaluator, successes []SuccessCases, failures []ErrorCases) {
+	t.Helper()
+	for _, mode := range modes {
+		for _, c := range successes {
+			t.Run(mode.name+"/"+c.template, func(t *testing.T) {
+				res, err := mode.evaluate(e, NewParser(c.template).Parse())
+				assert.Nil(t, err)
+				assert.Equal(t, c.expect, res)
+			})
+		}
+		for _, c := range failures {
+			t.Run(mode.name+"/"+c.template, func(t *testing.T) {
+				_, err := mode.evaluate(e, NewParser(c.template).Parse())
+				assert.ErrorContains(t, err, c.msg)
+			})
+		}
+	}
+}
+
 func TestExampleParser(t *testing.T) {
 	evaluator := NewInterpreter()
 	evaluator.SetMembers(createTestTemplateFunctions())
 	evaluator.SetTimeout(5 * time.Hour)
-	test := func(t *testing.T, cas *SuccessCases) {
-		ast := NewParser(cas.template).Parse()
-		res, err := evaluator.Evaluate(context.TODO(), ast)
+	for _, mode := range modes {
+		mode := mode
+		test := func(t *testing.T, cas *SuccessCases) {
+			ast := NewParser(cas.template).Parse()
+			res, err := mode.evaluate(evaluator, ast)
+			assert.Nil(t, err)
+			assert.Equal(t, cas.expect, res)
+		}
+		t.Run(mode.name, func(t *testing.T) {
+			for _, c := range cases {
+				if c.only {
+					t.Run(c.template, func(t *testing.T) {
+						test(t, &c)
+					})
+					return
+				}
+			}
+
+			for _, c := range cases {
+				t.Run(c.template, func(t *testing.T) {
+					test(t, &c)
+				})
+			}
+		})
+	}
+}
+
+func TestExampleParserErrors(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetTimeout(5 * time.Millisecond)
+	evaluator.SetMembers(createTestTemplateFunctions())
+	for _, mode := range modes {
+		mode := mode
+		test := func(t *testing.T, cas *ErrorCases) {
+			ast := NewParser(cas.template).Parse()
+			val, err := mode.evaluate(evaluator, ast)
+			assert.Nil(t, val)
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), cas.msg)
+		}
+		t.Run(mode.name, func(t *testing.T) {
+			for _, c := range errorCases {
+				if c.only {
+					t.Run(c.template, func(t *testing.T) {
+						test(t, &c)
+					})
+					return
+				}
+			}
+			for _, c := range errorCases {
+				t.Run(c.template, func(t *testing.T) {
+					test(t, &c)
+				})
+			}
+		})
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	source := "Hello\n@{{ [1,\n\t2 3] }}"
+	ast := NewParser(source).Parse()
+	err, ok := ast.(*ParseError)
+	assert.True(t, ok)
+	assert.Equal(t, 3, err.Line)
+	assert.Equal(t, 4, err.Column)
+	assert.Equal(t, 17, err.Offset)
+	assert.Equal(t, "3", err.Token.lexeme)
+	assert.Equal(t, "']'", err.Expected)
+	assert.Equal(t, "Error at line 3, column 4. Expect ']' after array expression. got 3", err.Error())
+	assert.Equal(t, "line 3, column 4: Expect ']' after array expression. got 3\n\t2 3] }}\n\t  ^\n", err.Format(source))
+}
+
+func TestParseAllRecoversFromErrors(t *testing.T) {
+	source := `@{{ [1, , 3] }} and @{{ 5 > }} then @{{ concat("a", 1 +, "b") }} @{{ 5 6 }} @{{ (1 + ) * 2 }} @{{ "ok" }}`
+	ast, errs := NewParser(source).ParseAll()
+	messages := make([]string, 0)
+	for _, err := range errs {
+		messages = append(messages, fmt.Sprintf("%d: %s", err.Column, err.Message))
+	}
+	assert.Equal(t, []string{
+		"9: Expect expression. got ,",
+		"29: Expect expression. got }}",
+		"56: Expect expression. got ,",
+		"72: Expect '}}' after expression. got 6",
+		"86: Expect expression. got )",
+	}, messages)
+	assert.ErrorContains(t, errs.Err(), "Expect expression. got , (and 4 more errors)")
+
+	template, ok := ast.(*Template)
+	assert.True(t, ok)
+	assert.Len(t, template.expressions, 11)
+	array := template.expressions[0].(*Array)
+	assert.IsType(t, &ParseError{}, array.values[1])
+	assert.IsType(t, &Literal{}, array.values[2])
+	assert.IsType(t, &ParseError{}, template.expressions[2])
+	call := template.expressions[4].(*Call)
+	assert.IsType(t, &Literal{}, call.arguments[0])
+	assert.IsType(t, &ParseError{}, call.arguments[1])
+	assert.IsType(t, &Literal{}, call.arguments[2])
+	assert.IsType(t, &ParseError{}, template.expressions[6])
+	assert.IsType(t, &Binary{}, template.expressions[8])
+	assert.Equal(t, "ok", template.expressions[10].(*Literal).value)
+
+	ast, errs = NewParser(`@{{ 1 + 2 }}`).ParseAll()
+	assert.Nil(t, errs)
+	assert.Nil(t, errs.Err())
+	assert.IsType(t, &Template{}, ast)
+
+	_, errs = NewExpressionParser(`f(1 +) 2`).ParseAll()
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "Expect expression. got )", errs[0].Message)
+	assert.Equal(t, "Expect end of expression. got 2", errs[1].Message)
+}
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(createTestTemplateFunctions())
+	source := "@{{ 1 + someObject.nested.missing.key }}"
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			_, err := mode.evaluate(evaluator, NewParser(source).Parse())
+			var runtimeErr *RuntimeError
+			assert.True(t, errors.As(err, &runtimeErr))
+			assert.Equal(t, Position{Offset: 8, Line: 1, Column: 9}, runtimeErr.Span.Start)
+			assert.Equal(t, Position{Offset: 37, Line: 1, Column: 38}, runtimeErr.Span.End)
+			assert.Equal(t, "Error at line 1, column 9. cannot get property 'key' of nil", err.Error())
+			assert.Equal(
+				t,
+				"line 1, column 9: cannot get property 'key' of nil\n"+
+					source+"\n"+
+					"        ^^^^^^^^^^^^^^^^^^^^^^^^^^^^^\n",
+				runtimeErr.Format(source),
+			)
+		})
+	}
+}
+
+func TestCustomDelimiters(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(createTestTemplateFunctions())
+	delimiters := []ParserOptions{
+		{LeftDelim: "{{", RightDelim: "}}"},
+		{LeftDelim: "${", RightDelim: "}"},
+		{LeftDelim: "<%=", RightDelim: "%>"},
+	}
+	for _, options := range delimiters {
+		t.Run(options.LeftDelim+options.RightDelim, func(t *testing.T) {
+			template := fmt.Sprintf("Hello %s someObject.key %s and %s {a: 1}.a %s!", options.LeftDelim, options.RightDelim, options.LeftDelim, options.RightDelim)
+			ast := NewParserWithOptions(template, options).Parse()
+			res, err := evaluator.Evaluate(context.TODO(), ast)
+			assert.Nil(t, err)
+			assert.Equal(t, "Hello value and 1!", res)
+
+			ast = NewParserWithOptions(options.LeftDelim+" 5 ", options).Parse()
+			_, err = evaluator.Evaluate(context.TODO(), ast)
+			assert.ErrorContains(t, err, fmt.Sprintf("Expect '%s' after expression", options.RightDelim))
+		})
+	}
+}
+
+func TestChainedComparisons(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(map[string]interface{}{"x": 5})
+	evaluator.SetTimeout(time.Second)
+	options := ParserOptions{Expression: true, ChainedComparisons: true}
+	expressions := []SuccessCases{
+		{template: `0 < x <= 10`, expect: true},
+		{template: `0 < x < 5`, expect: false},
+		{template: `10 > x > 0 == true`, expect: true},
+		{template: `1 < 2 < 3 < 4 >= 4`, expect: true},
+		{template: `x < 3 < "a"`, expect: false}, // short-circuits before comparing 3 with "a"
+		{template: `x > 3`, expect: true},
+	}
+	for _, mode := range modes {
+		for _, c := range expressions {
+			t.Run(mode.name+"/"+c.template, func(t *testing.T) {
+				res, err := mode.evaluate(evaluator, NewParserWithOptions(c.template, options).Parse())
+				assert.Nil(t, err)
+				assert.Equal(t, c.expect, res)
+			})
+		}
+		_, err := mode.evaluate(evaluator, NewParserWithOptions(`1 < 2 < "a"`, options).Parse())
+		assert.ErrorContains(t, err, "cannot compare float64 with string")
+	}
+}
+
+func TestSynchronousEvaluation(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetSynchronous(true)
+	evaluator.SetMembers(map[string]interface{}{
+		"panics": func() string { panic("boom") },
+		"deadline": func(ctx context.Context) bool {
+			_, ok := ctx.Deadline()
+			return ok
+		},
+	})
+
+	_, err := evaluator.Evaluate(context.TODO(), NewParser("@{{ panics() }}").Parse())
+	var evaluationErr *EvaluationError
+	assert.True(t, errors.As(err, &evaluationErr))
+	assert.Equal(t, "boom", err.Error())
+
+	res, err := evaluator.Evaluate(context.TODO(), NewParser("@{{ deadline() }}").Parse())
+	assert.Nil(t, err)
+	assert.Equal(t, true, res)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	_, err = evaluator.Evaluate(ctx, NewParser("@{{ 1 + 1 }}").Parse())
+	assert.EqualError(t, err, "evaluation canceled: context canceled")
+
+	evaluator.SetTimeout(time.Nanosecond)
+	long := "@{{ 0" + strings.Repeat(" + 1", 200) + " }}"
+	_, err = evaluator.Evaluate(context.TODO(), NewParser(long).Parse())
+	assert.EqualError(t, err, "evaluation canceled: context deadline exceeded")
+}
+
+func TestScopes(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(map[string]interface{}{
+		"upper": strings.ToUpper,
+		"name":  "member",
+	})
+	ast := NewParser("@{{ upper(name) }} from @{{ site ?? 'nowhere' }}").Parse()
+
+	globals := NewScope(nil, map[string]interface{}{"site": "example.com"})
+	request := NewScope(globals, map[string]interface{}{"name": "ada"})
+	assert.Equal(t, globals, request.Parent())
+	value, ok := request.Lookup("site")
+	assert.True(t, ok)
+	assert.Equal(t, "example.com", value)
+	_, ok = request.Lookup("upper")
+	assert.False(t, ok)
+
+	for _, synchronous := range []bool{false, true} {
+		evaluator.SetSynchronous(synchronous)
+		res, err := evaluator.EvaluateIn(context.TODO(), ast, request)
+		assert.Nil(t, err)
+		assert.Equal(t, "ADA from example.com", res)
+
+		res, err = evaluator.EvaluateWith(context.TODO(), ast, map[string]interface{}{"name": "grace"})
+		assert.Nil(t, err)
+		assert.Equal(t, "GRACE from nowhere", res)
+
+		res, err = evaluator.Evaluate(context.TODO(), ast)
 		assert.Nil(t, err)
-		assert.Equal(t, cas.expect, res)
+		assert.Equal(t, "MEMBER from nowhere", res)
+	}
+
+	program, err := evaluator.Compile(ast)
+	assert.Nil(t, err)
+	res, err := program.RunIn(context.TODO(), request)
+	assert.Nil(t, err)
+	assert.Equal(t, "ADA from example.com", res)
+	res, err = program.RunIn(context.TODO(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "MEMBER from nowhere", res)
+}
+
+func TestStrictMode(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(createTestTemplateFunctions())
+	evaluator.SetStrict(true)
+	successes := []SuccessCases{
+		{template: `@{{ someObject.key }}`, expect: "value"},
+		{template: `@{{ missing ?? "default" }}`, expect: "default"},
+		{template: `@{{ someObject.nested.missing ?: 2 }}`, expect: float64(2)},
+		{template: `@{{ (someObject['missing']) ?? 3 }}`, expect: float64(3)},
+		{template: `@{{ missing?.key }}`, expect: nil},
+		{template: `@{{ someObject?.missing }}`, expect: nil},
+		{template: `@{{ someObject.missing?.deep.path }}`, expect: nil},
+		{template: `@{{ dummy?.Missing ?? dummy.Exposed }}`, expect: ""},
+		{template: `@{{ 1 ?? missing }}`, expect: float64(1)},
 	}
-	for _, c := range cases {
-		if c.only {
-			t.Run(c.template, func(t *testing.T) {
-				test(t, &c)
+	failures := []ErrorCases{
+		{template: `@{{ missing }}`, msg: "Error at line 1, column 5. 'missing' is not defined"},
+		{template: `@{{ someObject.nmae }}`, msg: "'someObject.nmae' is not defined"},
+		{template: `@{{ someObject.nested['missing'] }}`, msg: `'someObject.nested["missing"]' is not defined`},
+		{template: `@{{ dummy.Missing }}`, msg: "'dummy.Missing' is not defined"},
+		{template: `@{{ someObject?.nested.missing }}`, msg: "'someObject?.nested.missing' is not defined"},
+		{template: `@{{ someObject[missing] ?? 1 }}`, msg: "'missing' is not defined"},
+		{template: `@{{ concat(missing) ?? 1 }}`, msg: "'missing' is not defined"},
+	}
+	for _, mode := range modes {
+		for _, c := range successes {
+			t.Run(mode.name+"/"+c.template, func(t *testing.T) {
+				res, err := mode.evaluate(evaluator, NewParser(c.template).Parse())
+				assert.Nil(t, err)
+				assert.Equal(t, c.expect, res)
+			})
+		}
+		for _, c := range failures {
+			t.Run(mode.name+"/"+c.template, func(t *testing.T) {
+				_, err := mode.evaluate(evaluator, NewParser(c.template).Parse())
+				var undefinedErr *UndefinedError
+				assert.True(t, errors.As(err, &undefinedErr))
+				assert.ErrorContains(t, err, c.msg)
 			})
-			return
 		}
 	}
+}
 
-	for _, c := range cases {
-		t.Run(c.template, func(t *testing.T) {
-			test(t, &c)
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits   Limits
+		template string
+		err      error
+	}{
+		{Limits{MaxSteps: 10}, "@{{ 1 + 2 }}", nil},
+		{Limits{MaxSteps: 10}, "@{{ 1 + 1 + 1 + 1 + 1 + 1 + 1 }}", &StepLimitError{}},
+		{Limits{MaxDepth: 5}, "@{{ (((1))) }}", nil},
+		{Limits{MaxDepth: 5}, "@{{ (((((1))))) }}", &DepthLimitError{}},
+		{Limits{MaxStringLength: 5}, "@{{ 'abc' + 'de' }}", nil},
+		{Limits{MaxStringLength: 5}, "@{{ 'abc' + 'def' }}", &StringLimitError{}},
+		{Limits{MaxStringLength: 5}, "@{{ format(date('2006', '2024'), '2006') }}", nil},
+		{Limits{MaxStringLength: 5}, "@{{ format(date('2006', '2024'), '2006-01') }}", &StringLimitError{}},
+		{Limits{MaxCollectionSize: 3}, "@{{ [1, 2, 3] }}", nil},
+		{Limits{MaxCollectionSize: 3}, "@{{ [1, 2, 3, 4] }}", &CollectionLimitError{}},
+		{Limits{MaxCollectionSize: 3}, "@{{ {a: 1, b: 2, c: 3, d: 4} }}", &CollectionLimitError{}},
+		{Limits{MaxOutputSize: 10}, "@{{ 'hello' }} you", nil},
+		{Limits{MaxOutputSize: 10}, "@{{ 'hello' }} world!", &OutputLimitError{}},
+		{Limits{MaxOutputSize: 10}, "@{{ 'hello' + ' world!' }}", &OutputLimitError{}},
+	}
+	for _, mode := range modes {
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("%s/%+v/%s", mode.name, test.limits, test.template), func(t *testing.T) {
+				evaluator := NewInterpreter()
+				evaluator.SetLimits(test.limits)
+				_, err := mode.evaluate(evaluator, NewParser(test.template).Parse())
+				if test.err == nil {
+					assert.Nil(t, err)
+					return
+				}
+				target := reflect.New(reflect.TypeOf(test.err)).Interface()
+				assert.True(t, errors.As(err, target), "%v is not a %T", err, test.err)
+			})
+		}
+	}
+
+	_, err := NewInterpreter().Evaluate(context.TODO(), NewParser("@{{ 'abc' + 'def' }}").Parse())
+	assert.Nil(t, err)
+}
+
+func TestParserMaxDepth(t *testing.T) {
+	options := ParserOptions{Expression: true, MaxDepth: 4}
+	_, ok := NewParserWithOptions("[[1], -2]", options).Parse().(*ParseError)
+	assert.False(t, ok)
+
+	for _, source := range []string{"[[[[1]]]]", "((((1))))", "- - - - 1", "not not not not a", "f(g(h(i(1))))"} {
+		ast := NewParserWithOptions(source, options).Parse()
+		err, ok := ast.(*ParseError)
+		assert.True(t, ok, source)
+		var depthErr *DepthLimitError
+		assert.True(t, errors.As(err, &depthErr), source)
+		assert.Equal(t, 4, depthErr.Limit)
+	}
+
+	_, errs := NewParserWithOptions("@{{ ((((1)))) }} @{{ a b }}", ParserOptions{MaxDepth: 4}).ParseAll()
+	assert.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Message, "Expression is nested too deeply")
+}
+
+func TestProgramRun(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(map[string]interface{}{"greeting": "Hello", "name": "member"})
+	program, err := evaluator.Compile(NewParser("@{{ greeting }} @{{ name }}@{{ user?.suffix ?? '!' }}").Parse())
+	assert.Nil(t, err)
+
+	res, err := program.Run(context.TODO(), map[string]interface{}{"name": "env"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello env!", res)
+
+	res, err = program.Run(context.TODO(), map[string]interface{}{"user": map[string]interface{}{"suffix": "?"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello member?", res)
+
+	_, err = evaluator.Compile(NewParser("@{{ 1 + }}").Parse())
+	assert.ErrorContains(t, err, "parse error: Error at line 1, column 9. Expect expression.")
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		probe := &probe{}
+		evaluator := NewInterpreter()
+		evaluator.SetMembers(map[string]interface{}{
+			"items": make([]int, 10000),
+			"probe": probe,
+			"stop": func() bool {
+				cancel()
+				return false
+			},
 		})
+		// The lambda cancels the run on the first item, then only compares
+		// items with probe, which calls no function.
+		program, err := evaluator.Compile(NewParser("@{{ items.filter((x, i) => i == 0 ? stop() : x == probe) }}").Parse())
+		assert.Nil(t, err)
+		_, err = program.Run(ctx, nil)
+		assert.EqualError(t, err, "evaluation canceled: context canceled")
+		assert.Less(t, probe.calls, clockInterval)
+	})
+}
+
+// probe counts the comparisons it is part of.
+type probe struct {
+	calls int
+}
+
+func (p *probe) Equal(interface{}) bool {
+	p.calls++
+	return false
+}
+
+func TestAccessPolicy(t *testing.T) {
+	type Vault struct {
+		*Account
+		Label string
+	}
+	type Safe struct {
+		Account
+	}
+	accountType := reflect.TypeOf(Account{})
+	vaultType := reflect.TypeOf(Vault{})
+	tests := []struct {
+		name     string
+		policy   AccessPolicy
+		template string
+		expect   interface{}
+		err      string
+	}{
+		{"none", nil, "@{{ account.Withdraw(10) }}", float64(90), ""},
+		{"none", nil, "@{{ account.Password }}", nil, ""},
+		{"none", nil, "@{{ account['Password'] }}", nil, ""},
+		{"allowlist", NewAllowlist().AllowFields(accountType, "Name").AllowMethods(accountType, "Balance"), "@{{ account.Name }} @{{ account.Balance() }}", "Ada 100", ""},
+		{"allowlist", NewAllowlist().AllowFields(accountType, "Name"), "@{{ account.Withdraw(10) }}", nil, "access to method 'Withdraw' of parser.Account is denied"},
+		{"allowlist", NewAllowlist().AllowMethods(accountType, "Balance"), "@{{ account['Name'] }}", nil, "access to field 'Name' of parser.Account is denied"},
+		{"allowlist", NewAllowlist().AllowType(reflect.TypeOf(&Account{})), "@{{ account.Withdraw(10) }}", float64(90), ""},
+		{"allowlist", NewAllowlist().AllowType(accountType), "@{{ account.Password }}", nil, ""},
+		{"denylist", NewDenylist().DenyMethods(accountType, "Withdraw"), "@{{ account.Name }} @{{ account.Balance() }}", "Ada 100", ""},
+		{"denylist", NewDenylist().DenyMethods(accountType, "Withdraw"), "@{{ account['Withdraw'](10) }}", nil, "access to method 'Withdraw' of parser.Account is denied"},
+		{"denylist", NewDenylist().DenyType(accountType), "@{{ account?.Name ?? 'anonymous' }}", nil, "access to field 'Name' of parser.Account is denied"},
+		{"embedded", NewDenylist().DenyFields(accountType, "Name"), "@{{ vault.Name }}", nil, "access to field 'Name' of parser.Account is denied"},
+		{"embedded", NewDenylist().DenyFields(accountType, "Name"), "@{{ safe['Name'] }}", nil, "access to field 'Name' of parser.Account is denied"},
+		{"embedded", NewDenylist().DenyMethods(accountType, "Withdraw"), "@{{ vault.Withdraw(10) }}", nil, "access to method 'Withdraw' of parser.Account is denied"},
+		{"embedded", NewDenylist().DenyMethods(accountType, "Withdraw"), "@{{ safe.Withdraw(10) }}", nil, "access to method 'Withdraw' of parser.Account is denied"},
+		{"embedded", NewAllowlist().AllowType(vaultType), "@{{ vault.Label }}", "main", ""},
+		{"embedded", NewAllowlist().AllowType(vaultType), "@{{ vault.Balance() }}", nil, "access to method 'Balance' of parser.Account is denied"},
+		{"embedded", NewAllowlist().AllowType(vaultType).AllowFields(accountType, "Name").AllowMethods(accountType, "Balance"), "@{{ vault.Name }} @{{ vault.Balance() }}", "Ada 100", ""},
 	}
+	for _, mode := range modes {
+		for _, test := range tests {
+			t.Run(mode.name+"/"+test.name+"/"+test.template, func(t *testing.T) {
+				evaluator := NewInterpreter()
+				evaluator.SetMembers(map[string]interface{}{
+					"account": &Account{Name: "Ada", Password: "secret", balance: 100},
+					"vault":   &Vault{Account: &Account{Name: "Ada", balance: 100}, Label: "main"},
+					"safe":    &Safe{Account: Account{Name: "Ada", balance: 100}},
+				})
+				evaluator.SetAccessPolicy(test.policy)
+				res, err := mode.evaluate(evaluator, NewParser(test.template).Parse())
+				if test.err == "" {
+					assert.Nil(t, err)
+					assert.Equal(t, test.expect, res)
+					return
+				}
+				var accessErr *AccessError
+				assert.True(t, errors.As(err, &accessErr))
+				assert.ErrorContains(t, err, test.err)
+			})
+		}
+	}
+}
 
+func TestExport(t *testing.T) {
+	type Vault struct {
+		*Account
+		Label string
+	}
+	type Node struct {
+		Next *Node
+	}
+	cyclic := &Node{}
+	cyclic.Next = cyclic
+	accountType := reflect.TypeOf(Account{})
+	user := &User{Profile: &Profile{Timezone: "UTC"}, FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com"}
+	tests := []struct {
+		name   string
+		value  interface{}
+		policy AccessPolicy
+		naming FieldNaming
+		expect interface{}
+		err    string
+	}{
+		{"hidden", &Account{Name: "Ada", Password: "secret"}, nil, FieldNaming{}, map[string]interface{}{"Name": "Ada"}, ""},
+		{"denied", &Vault{Account: &Account{Name: "Ada"}, Label: "main"}, NewDenylist().DenyFields(accountType, "Name"), FieldNaming{}, map[string]interface{}{"Label": "main"}, ""},
+		{"nil embedded", Vault{Label: "main"}, nil, FieldNaming{}, map[string]interface{}{"Label": "main"}, ""},
+		{"naming", user, nil, FieldNaming{JSONTags: true}, map[string]interface{}{"first_name": "Ada", "surname": "Lovelace", "time_zone": "UTC"}, ""},
+		{"elements", []interface{}{1, "a", []byte("b"), map[int]Account{1: {Name: "Ada"}}}, nil, FieldNaming{}, []interface{}{1, "a", []byte("b"), map[int]interface{}{1: map[string]interface{}{"Name": "Ada"}}}, ""},
+		{"function", []interface{}{func() {}}, nil, FieldNaming{}, nil, "cannot export func()"},
+		{"cyclic", cyclic, nil, FieldNaming{}, nil, "cannot export cyclic *parser.Node"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evaluator := NewInterpreter()
+			evaluator.SetAccessPolicy(test.policy)
+			evaluator.SetFieldNaming(test.naming)
+			res, err := evaluator.Export(test.value)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.expect, res)
+		})
+	}
 }
 
-func TestExampleParserErrors(t *testing.T) {
+func TestFieldNaming(t *testing.T) {
+	user := User{Profile: &Profile{Timezone: "UTC"}, FirstName: "Ada", LastName: "Lovelace", Email: "ada@example.com"}
+	tests := []struct {
+		naming   FieldNaming
+		template string
+		expect   interface{}
+	}{
+		{FieldNaming{}, "@{{ user.FirstName }} @{{ user.surname }}", "Ada Lovelace"},
+		{FieldNaming{}, "@{{ user.LastName }}", nil},
+		{FieldNaming{}, "@{{ user.Timezone }}", "UTC"},
+		{FieldNaming{}, "@{{ user.first_name }}", nil},
+		{FieldNaming{JSONTags: true}, "@{{ user.first_name }} @{{ user['surname'] }}", "Ada Lovelace"},
+		{FieldNaming{JSONTags: true}, "@{{ user.time_zone }}", "UTC"},
+		{FieldNaming{JSONTags: true}, "@{{ user.Email }}", nil},
+		{FieldNaming{JSONTags: true, CaseInsensitive: true}, "@{{ user.email }}", nil},
+		{FieldNaming{}, "@{{ user.Email }}", "ada@example.com"},
+		{FieldNaming{JSONTags: true}, "@{{ user.FirstName }}", nil},
+		{FieldNaming{LowerFirst: true}, "@{{ user.firstName }} @{{ user.timezone }}", "Ada UTC"},
+		{FieldNaming{LowerFirst: true}, "@{{ user.FirstName }}", nil},
+		{FieldNaming{CaseInsensitive: true}, "@{{ user.firstname }} @{{ user.SURNAME }}", "Ada Lovelace"},
+		{FieldNaming{JSONTags: true, CaseInsensitive: true}, "@{{ user.First_Name }}", "Ada"},
+	}
+	for _, mode := range modes {
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("%s/%+v/%s", mode.name, test.naming, test.template), func(t *testing.T) {
+				evaluator := NewInterpreter()
+				evaluator.SetMembers(map[string]interface{}{"user": user})
+				evaluator.SetFieldNaming(test.naming)
+				res, err := mode.evaluate(evaluator, NewParser(test.template).Parse())
+				assert.Nil(t, err)
+				assert.Equal(t, test.expect, res)
+			})
+		}
+	}
+
 	evaluator := NewInterpreter()
-	evaluator.SetTimeout(5 * time.Millisecond)
-	evaluator.SetMembers(createTestTemplateFunctions())
-	test := func(t *testing.T, cas *ErrorCases) {
-		ast := NewParser(cas.template).Parse()
-		val, err := evaluator.Evaluate(context.TODO(), ast)
-		assert.Nil(t, val)
-		assert.NotNil(t, err)
-		assert.Contains(t, err.Error(), cas.msg)
-	}
-	for _, c := range errorCases {
-		if c.only {
-			t.Run(c.template, func(t *testing.T) {
-				test(t, &c)
+	evaluator.SetMembers(map[string]interface{}{"user": User{}})
+	_, err := evaluator.Evaluate(context.TODO(), NewParser("@{{ user.Timezone }}").Parse())
+	assert.ErrorContains(t, err, "nil pointer to embedded struct")
+}
+
+func TestNumberModes(t *testing.T) {
+	tests := []struct {
+		mode     NumberMode
+		template string
+		expect   interface{}
+		err      string
+	}{
+		{FloatNumbers, "@{{ 9007199254740993 + 0 }}", float64(9007199254740992), ""},
+		{FloatNumbers, "@{{ small + 1 }}", float64(4), ""},
+		{FloatNumbers, "@{{ 0x10 + 0b11 + 0o17 + 1_000 }}", float64(1034), ""},
+		{FloatNumbers, "@{{ 0xffffffffffffffffff }}", float64(1<<72 - 1), ""},
+		{IntegerNumbers, "@{{ 9007199254740993 + 0 }}", int64(9007199254740993), ""},
+		{IntegerNumbers, "@{{ id + 1 }}", int64(9007199254740994), ""},
+		{IntegerNumbers, "@{{ 0x10 + 0b11 + 1_000 }}", int64(1019), ""},
+		{IntegerNumbers, "@{{ small * 2 - 1 }}", int64(5), ""},
+		{IntegerNumbers, "@{{ -7 // 2 }} @{{ -7 % 3 }} @{{ 2 ** 62 }}", "-4 -1 4611686018427387904", ""},
+		{IntegerNumbers, "@{{ 7 / 2 }}", 3.5, ""},
+		{IntegerNumbers, "@{{ 1 + 0.5 }}", 1.5, ""},
+		{IntegerNumbers, "@{{ 3 == 3.0 && small == 3 && 2 < 2.5 }}", true, ""},
+		{IntegerNumbers, "@{{ [1, 2, 3][small - 2] }}", int64(2), ""},
+		{IntegerNumbers, "@{{ 'abc'.length }}", int64(3), ""},
+		{IntegerNumbers, "@{{ -small }}", int64(-3), ""},
+		{IntegerNumbers, "@{{ 9223372036854775807 + 1 }}", nil, "integer overflow: 9223372036854775807 + 1"},
+		{IntegerNumbers, "@{{ 2 ** 63 }}", nil, "integer overflow: 2 ** 63"},
+		{IntegerNumbers, "@{{ -9223372036854775807 - 2 }}", nil, "integer overflow"},
+		{IntegerNumbers, "@{{ 1 // 0 }}", nil, "cannot divide by zero"},
+		{DecimalNumbers, "@{{ 0.1 + 0.2 }}", "0.3", ""},
+		{DecimalNumbers, "@{{ 0.1 + 0.2 == 0.3 }}", true, ""},
+		{DecimalNumbers, "@{{ 19.99 * 3 }} @{{ 1 / 3 }}", "59.97 0.3333333333333333333333333333333333", ""},
+		{DecimalNumbers, "@{{ 10 / 4 }} @{{ -7.5 % 2 }} @{{ 7.5 // 2 }}", "2.5 -1.5 3", ""},
+		{DecimalNumbers, "@{{ 1.5 ** 2 }} @{{ 2 ** -2 }} @{{ price + small }}", "2.25 0.25 4.25", ""},
+		{DecimalNumbers, "@{{ -price < 0 }}", true, ""},
+		{DecimalNumbers, "@{{ 1.5 / 0 }}", nil, "cannot divide by zero"},
+		{DecimalNumbers, "@{{ {[0.5]: 'half'} }}", map[string]interface{}{"0.5": "half"}, ""},
+	}
+	for _, mode := range modes {
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("%s/%d/%s", mode.name, test.mode, test.template), func(t *testing.T) {
+				evaluator := NewInterpreter()
+				evaluator.SetMembers(map[string]interface{}{
+					"id":    int64(9007199254740993),
+					"small": 3,
+					"price": 1.25,
+				})
+				evaluator.SetNumberMode(test.mode)
+				res, err := mode.evaluate(evaluator, NewParser(test.template).Parse())
+				if test.err != "" {
+					assert.ErrorContains(t, err, test.err)
+					return
+				}
+				assert.Nil(t, err)
+				if decimal, ok := res.(*big.Rat); ok {
+					res = formatDecimal(decimal)
+				}
+				assert.Equal(t, test.expect, res)
 			})
-			return
 		}
 	}
-	for _, c := range errorCases {
+
+	t.Run("invalid literal", func(t *testing.T) {
+		_, errs := NewParser("@{{ 0x + 1 }}").ParseAll()
+		assert.ErrorContains(t, errs, "Invalid number. invalid number \"0x\"")
+	})
+}
+
+func TestLambdas(t *testing.T) {
+	type item struct {
+		Name   string
+		Price  float64
+		Active bool
+	}
+	members := map[string]interface{}{
+		"items": []interface{}{
+			item{Name: "tea", Price: 3, Active: true},
+			item{Name: "cake", Price: 5, Active: false},
+			item{Name: "pie", Price: 4, Active: true},
+		},
+		"filter": func(items []interface{}, keep func(interface{}) bool) []interface{} {
+			kept := make([]interface{}, 0)
+			for _, item := range items {
+				if keep(item) {
+					kept = append(kept, item)
+				}
+			}
+			return kept
+		},
+		"mapItems": func(items []interface{}, transform func(interface{}) interface{}) []interface{} {
+			mapped := make([]interface{}, len(items))
+			for i, item := range items {
+				mapped[i] = transform(item)
+			}
+			return mapped
+		},
+		"reduce": func(items []interface{}, reducer func(interface{}, interface{}) (interface{}, error), initial interface{}) (interface{}, error) {
+			accumulator := initial
+			for _, item := range items {
+				var err error
+				if accumulator, err = reducer(accumulator, item); err != nil {
+					return nil, err
+				}
+			}
+			return accumulator, nil
+		},
+		"apply": func(ctx context.Context, fn func(context.Context, float64) float64, value float64) float64 {
+			return fn(ctx, value)
+		},
+		"closure": func(fn interface{}) bool {
+			_, ok := fn.(*Closure)
+			return ok
+		},
+		"factor": 10,
+	}
+	successes := []SuccessCases{
+		{template: "@{{ (x => x * 2)(21) }}", expect: float64(42)},
+		{template: "@{{ ((a, b) => a + b)(1, 2) }}", expect: float64(3)},
+		{template: "@{{ (() => 'hi')() }}", expect: "hi"},
+		{template: "@{{ ((a, b) => b)(1) }}", expect: nil},
+		{template: "@{{ mapItems(filter(items, x => x.Active), x => x.Name) }}", expect: []interface{}{"tea", "pie"}},
+		{template: "@{{ reduce(items, (sum, x) => sum + x.Price, 0) }}", expect: float64(12)},
+		{template: "@{{ apply(x => x * factor, 4) }}", expect: float64(40)},
+		{template: "@{{ (factor => x => x * factor)(3)(5) }}", expect: float64(15)},
+		{template: "@{{ mapItems(items, factor => factor.Price) }} @{{ factor }}", expect: "[3 5 4] 10"},
+		{template: "@{{ closure(x => x) }}", expect: true},
+	}
+	failures := []ErrorCases{
+		{template: "@{{ filter(items, x => x.Price) }}", msg: "lambda returned 3, which cannot be used as bool"},
+		{template: "@{{ filter(items, x => x.Price / 0) }}", msg: "cannot divide by zero"},
+		{template: "@{{ reduce(items, (sum, x) => sum + x, 0) }}", msg: "cannot add non-numbers or strings"},
+		{template: "@{{ (a, a) => a }}", msg: "Duplicate parameter 'a'."},
+	}
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(members)
+	runCases(t, evaluator, successes, failures)
+}
+
+func TestCollectionMethods(t *testing.T) {
+	type order struct {
+		Customer string
+		Total    float64
+	}
+	members := map[string]interface{}{
+		"numbers": []int{3, 1, 2, 3},
+		"words":   [2]string{"b", "a"},
+		"nested":  []interface{}{1.0, []interface{}{2.0, []int{3}}},
+		"orders": []order{
+			{Customer: "ada", Total: 10},
+			{Customer: "bob", Total: 5},
+			{Customer: "ada", Total: 2.5},
+		},
+		"stock":  map[string]int{"tea": 2, "pie": 0, "cake": 1},
+		"ranks":  map[int]string{1: "gold", 2: "silver"},
+		"config": map[string]interface{}{"keys": "own key"},
+		"double": func(n int) int { return n * 2 },
+	}
+	successes := []SuccessCases{
+		{template: "@{{ numbers.map(n => n * 2) }}", expect: []interface{}{float64(6), float64(2), float64(4), float64(6)}},
+		{template: "@{{ numbers.map((n, i) => i) }}", expect: []interface{}{float64(0), float64(1), float64(2), float64(3)}},
+		{template: "@{{ numbers.map(double) }}", expect: []interface{}{6, 2, 4, 6}},
+		{template: "@{{ numbers.filter(n => n > 1) }}", expect: []interface{}{3, 2, 3}},
+		{template: "@{{ numbers.reduce((sum, n) => sum + n, 0) }}", expect: float64(9)},
+		{template: "@{{ numbers.reduce((a, b) => a > b ? a : b) }}", expect: 3},
+		{template: "@{{ orders.find(o => o.Total < 6).Customer }}", expect: "bob"},
+		{template: "@{{ orders.find(o => o.Total > 100) }}", expect: nil},
+		{template: "@{{ numbers.some(n => n == 2) }} @{{ numbers.every(n => n > 1) }}", expect: "true false"},
+		{template: "@{{ numbers.includes(2) }} @{{ numbers.indexOf(3) }} @{{ numbers.indexOf(9) }}", expect: "true 0 -1"},
+		{template: "@{{ words.join() }} @{{ numbers.join(' + ') }}", expect: "b,a 3 + 1 + 2 + 3"},
+		{template: "@{{ numbers.slice(1) }} @{{ numbers.slice(-2) }} @{{ numbers.slice(1, -1) }}", expect: "[1 2 3] [2 3] [1 2]"},
+		{template: "@{{ numbers.sort() }} @{{ words.sort() }} @{{ numbers }}", expect: "[1 2 3 3] [a b] [3 1 2 3]"},
+		{template: "@{{ orders.sort((a, b) => a.Total - b.Total).map(o => o.Total) }}", expect: []interface{}{2.5, float64(5), float64(10)}},
+		{template: "@{{ numbers.reverse() }} @{{ numbers.unique() }}", expect: "[3 2 1 3] [3 1 2]"},
+		{template: "@{{ nested.flat() }} @{{ nested.flat(2) }}", expect: "[1 2 [3]] [1 2 3]"},
+		{template: "@{{ numbers.sum() }} @{{ orders.sum(o => o.Total) }} @{{ [].sum() }}", expect: "9 17.5 0"},
+		{template: "@{{ numbers.min() }} @{{ numbers.max() }} @{{ words.max() }} @{{ [].min() }}", expect: "1 3 b <nil>"},
+		{template: "@{{ orders.max((a, b) => a.Total - b.Total).Customer }}", expect: "ada"},
+		{template: "@{{ orders.groupBy(o => o.Customer).ada.length }}", expect: float64(2)},
+		{template: "@{{ stock.keys() }} @{{ stock.values() }}", expect: "[cake pie tea] [1 0 2]"},
+		{template: "@{{ stock.entries()[0] }}", expect: []interface{}{"cake", 1}},
+		{template: "@{{ stock.has('tea') }} @{{ stock.has('milk') }}", expect: "true false"},
+		{template: "@{{ stock.merge({milk: 4}, {tea: 3}) }}", expect: map[string]interface{}{"tea": float64(3), "pie": 0, "cake": 1, "milk": float64(4)}},
+		{template: "@{{ stock.pick('tea', 'milk') }} @{{ stock.pick(['pie']) }}", expect: "map[tea:2] map[pie:0]"},
+		{template: "@{{ config.keys }}", expect: "own key"},
+		{template: "@{{ ranks[1] }} @{{ ranks[numbers[2]] }}", expect: "gold silver"},
+		{template: "@{{ 1 in ranks }} @{{ 1.5 in ranks }} @{{ ranks.has(1.5) }}", expect: "true false false"},
+		{template: "@{{ ranks[1.5] ?? 'none' }} @{{ ranks['1'] ?? 'none' }} @{{ ranks[[1]] ?? 'none' }}", expect: "none none none"},
+	}
+	failures := []ErrorCases{
+		{template: "@{{ numbers.map() }}", msg: "method 'map' expects 1 arguments, got 0"},
+		{template: "@{{ numbers.slice(1, 2, 3) }}", msg: "method 'slice' expects 0 to 2 arguments, got 3"},
+		{template: "@{{ [].reduce((a, b) => a + b) }}", msg: "cannot reduce an empty array without an initial value"},
+		{template: "@{{ [1, 'a'].sort() }}", msg: "cannot compare string with float64"},
+		{template: "@{{ numbers.sort((a, b) => 'x') }}", msg: "comparator returned x, which is not a number"},
+		{template: "@{{ numbers.map(n => n.x) }}", msg: "Error at line 1, column 22. cannot get property 'x' of type int"},
+		{template: "@{{ numbers.pop() }}", msg: "property 'pop' does not exist"},
+	}
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(members)
+	runCases(t, evaluator, successes, failures)
+}
+
+func TestStringMethods(t *testing.T) {
+	members := map[string]interface{}{
+		"name":  "Ada Lovelace",
+		"city":  "Zürich",
+		"blank": "  padded\t",
+	}
+	successes := []SuccessCases{
+		{template: "@{{ name.upper() }} @{{ name.lower() }}", expect: "ADA LOVELACE ada lovelace"},
+		{template: "[@{{ blank.trim() }}] [@{{ blank.trimStart() }}] [@{{ blank.trimEnd() }}]", expect: "[padded] [padded\t] [  padded]"},
+		{template: "@{{ '--a--'.trim('-') }} @{{ '--a--'.trimStart('-') }} @{{ '--a--'.trimEnd('-') }}", expect: "a a-- --a"},
+		{template: "@{{ name.split(' ') }}", expect: []interface{}{"Ada", "Lovelace"}},
+		{template: "@{{ city.split('') }} @{{ 'a,b,c'.split(',', 2) }}", expect: "[Z ü r i c h] [a b]"},
+		{template: "@{{ 'a-b-c'.replace('-', '+') }} @{{ 'a-b-c'.replaceAll('-', '+') }}", expect: "a+b-c a+b+c"},
+		{template: "@{{ name.startsWith('Ada') }} @{{ name.endsWith('Ada') }} @{{ name.contains('Love') }}", expect: "true false true"},
+		{template: "@{{ '7'.padStart(3, '0') }} @{{ 'ab'.padEnd(5, 'xy') }} [@{{ city.padStart(8) }}]", expect: "007 abxyx [  Zürich]"},
+		{template: "@{{ 'ab'.repeat(3) }}[@{{ 'ab'.repeat(0) }}]", expect: "ababab[]"},
+		{template: "@{{ city.substring(1, 3) }} @{{ city.substring(3, 1) }} @{{ city.substring(-2) }}", expect: "ür ür Zürich"},
+		{template: "@{{ city.charAt(1) }}[@{{ city.charAt(10) }}]", expect: "ü[]"},
+		{template: "@{{ city[1] }}", expect: "ü"},
+		{template: "@{{ city[5] }} @{{ name[0] }}", expect: "h A"},
+		{template: "@{{ city.indexOf('rich') }} @{{ city.indexOf('x') }}", expect: "2 -1"},
+		{template: "@{{ city.length }}", expect: float64(6)},
+		{template: "@{{ name.split(' ').map(w => w.charAt(0)).join('') }}", expect: "AL"},
+	}
+	failures := []ErrorCases{
+		{template: "@{{ name.upper(1) }}", msg: "method 'upper' expects 0 arguments, got 1"},
+		{template: "@{{ name.split(1) }}", msg: "separator '1' is not a string"},
+		{template: "@{{ name.charAt('a') }}", msg: "index 'a' is not an integer"},
+		{template: "@{{ city[6] }}", msg: "index '6' is out of bounds"},
+		{template: "@{{ city['a'] }}", msg: "index 'a' is not an integer"},
+		{template: "@{{ name.repeat(-1) }}", msg: "cannot repeat a string -1 times"},
+		{template: "@{{ name.reverse() }}", msg: "property 'reverse' does not exist"},
+	}
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(members)
+	runCases(t, evaluator, successes, failures)
+
+	t.Run("limits", func(t *testing.T) {
+		evaluator := NewInterpreter()
+		evaluator.SetLimits(Limits{MaxStringLength: 5})
+		for _, template := range []string{"@{{ 'ab'.repeat(3) }}", "@{{ 'ab'.padEnd(6) }}", "@{{ 'abab'.replaceAll('b', 'bbb') }}"} {
+			_, err := evaluator.Evaluate(context.Background(), NewParser(template).Parse())
+			var limit *StringLimitError
+			assert.True(t, errors.As(err, &limit), template)
+		}
+	})
+}
+
+func TestTimes(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	members := map[string]interface{}{
+		"start":   start,
+		"end":     time.Date(2024, time.January, 1, 17, 30, 0, 0, time.UTC),
+		"timeout": 30 * time.Second,
+	}
+	successes := []SuccessCases{
+		{template: "@{{ end - start }}", expect: 8*time.Hour + 30*time.Minute},
+		{template: "@{{ start + 2h30m }}", expect: start.Add(150 * time.Minute)},
+		{template: "@{{ 2h30m + start }}", expect: start.Add(150 * time.Minute)},
+		{template: "@{{ start - 1d }}", expect: start.AddDate(0, 0, -1)},
+		{template: "@{{ end > start }} @{{ end <= start }} @{{ end - start > 8h }}", expect: "true false true"},
+		{template: "@{{ start == date('RFC3339', '2024-01-01T10:00:00+01:00') }}", expect: true},
+		{template: "@{{ start in [date('DateOnly', '2023-12-31') + 33h] }}", expect: true},
+		{template: "@{{ 1d == 24h }} @{{ -1h }} @{{ 1.5h }} @{{ 500ms + 1_000ms }}", expect: "true -1h0m0s 1h30m0s 1.5s"},
+		{template: "@{{ 90s * 2 }} @{{ 2 * 90s }} @{{ 1h / 4 }} @{{ timeout * 0.5 }}", expect: "3m0s 3m0s 15m0s 15s"},
+		{template: "@{{ [2h, 30m, 1h].sort() }}", expect: []interface{}{30 * time.Minute, time.Hour, 2 * time.Hour}},
+		{template: "@{{ now() }}", expect: start},
+		{template: "@{{ now() - start < 1m }}", expect: true},
+		{template: "@{{ format(start, 'DateOnly') }} @{{ format(end, '15:04') }}", expect: "2024-01-01 17:30"},
+		{template: "@{{ format(timezone(start, 'Asia/Tokyo'), 'Kitchen') }}", expect: "6:00PM"},
+	}
+	failures := []ErrorCases{
+		{template: "@{{ start + start }}", msg: "cannot apply + to time.Time and time.Time"},
+		{template: "@{{ start * 2 }}", msg: "cannot apply * to time.Time"},
+		{template: "@{{ 1h / 0 }}", msg: "division by zero"},
+		{template: "@{{ start > 1 }}", msg: "cannot compare time.Time with"},
+		{template: "@{{ date('DateOnly', 'soon') }}", msg: `cannot parse "soon"`},
+		{template: "@{{ timezone(start, 'Mars/Olympus') }}", msg: "unknown time zone Mars/Olympus"},
+	}
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(members)
+	evaluator.SetClock(func() time.Time { return start })
+	runCases(t, evaluator, successes, failures)
+
+	t.Run("number modes", func(t *testing.T) {
+		for mode, expect := range map[NumberMode]interface{}{
+			FloatNumbers:   float64(2),
+			IntegerNumbers: float64(2),
+			DecimalNumbers: big.NewRat(2, 1),
+		} {
+			evaluator := NewInterpreter()
+			evaluator.SetNumberMode(mode)
+			res, err := evaluator.Evaluate(context.Background(), NewParser("@{{ 1h / 30m }}").Parse())
+			assert.Nil(t, err)
+			assert.Equal(t, expect, res)
+			res, err = evaluator.Evaluate(context.Background(), NewParser("@{{ 1m * 2 }}").Parse())
+			assert.Nil(t, err)
+			assert.Equal(t, 2*time.Minute, res)
+		}
+	})
+
+	t.Run("members shadow built-ins", func(t *testing.T) {
+		evaluator := NewInterpreter()
+		evaluator.AddMember("now", "member")
+		res, err := evaluator.Evaluate(context.Background(), NewParser("@{{ now }}").Parse())
+		assert.Nil(t, err)
+		assert.Equal(t, "member", res)
+	})
+
+	t.Run("invalid literal", func(t *testing.T) {
+		_, errs := NewParser("@{{ 99999999999h }}").ParseAll()
+		assert.ErrorContains(t, errs, "Invalid duration. duration \"99999999999h\" is too long")
+	})
+}
+
+// Money is an Equaler that equals numbers of the same amount.
+type Money struct {
+	Cents int64
+}
+
+func (m Money) Equal(other interface{}) bool {
+	switch other := other.(type) {
+	case Money:
+		return m.Cents == other.Cents
+	case float64:
+		return float64(m.Cents) == other*100
+	}
+	return false
+}
+
+func TestEquality(t *testing.T) {
+	type point struct {
+		X, Y  int
+		label string
+	}
+	type tagged struct {
+		Tags []string
+		Meta map[string]interface{}
+	}
+	members := map[string]interface{}{
+		"ints":   []int{1, 2, 3},
+		"floats": [3]float64{1, 2, 3},
+		"counts": map[string]int{"a": 1, "b": 2},
+		"p1":     point{X: 1, Y: 2, label: "a"},
+		"p2":     &point{X: 1, Y: 2, label: "a"},
+		"p3":     point{X: 1, Y: 2, label: "b"},
+		"t1":     tagged{Tags: []string{"x"}, Meta: map[string]interface{}{"n": 1}},
+		"t2":     tagged{Tags: []string{"x"}, Meta: map[string]interface{}{"n": 1.0}},
+		"price":  Money{Cents: 250},
+		"int3":   int(3),
+		"uint3":  uint8(3),
+	}
+	successes := []SuccessCases{
+		{template: "@{{ [1, 2] == [1, 2] }} @{{ [1, 2] != [2, 1] }} @{{ [] == [] }}", expect: "true true true"},
+		{template: "@{{ ints == [1, 2, 3] }} @{{ ints == floats }} @{{ ints == [1, 2] }}", expect: "true true false"},
+		{template: "@{{ [[1, 'a'], {b: [nil]}] == [[1, 'a'], {b: [nil]}] }}", expect: true},
+		{template: "@{{ {a: 1, b: 2} == {b: 2, a: 1} }} @{{ counts == {a: 1, b: 2} }} @{{ counts == {a: 1} }}", expect: "true true false"},
+		{template: "@{{ {a: 1} == [1] }} @{{ {a: nil} == {b: nil} }} @{{ {a: [1]} == {a: [1.0]} }}", expect: "false false true"},
+		{template: "@{{ int3 == 3.0 }} @{{ int3 == uint3 }} @{{ int3 == '3' }}", expect: "true true false"},
+		{template: "@{{ p1 == p2 }} @{{ p1 == p3 }} @{{ t1 == t2 }}", expect: "true false true"},
+		{template: "@{{ price == 2.5 }} @{{ 2.5 == price }} @{{ price == 2 }}", expect: "true true false"},
+		{template: "@{{ [1, 2] in [[1, 2], [3]] }} @{{ [[1], [1.0], [2]].unique() }}", expect: "true [[1] [2]]"},
+		{template: "@{{ [{a: 1}, {a: 2}].indexOf({a: 2}) }} @{{ [ints].includes([1, 2, 3]) }}", expect: "1 true"},
+	}
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(members)
+	runCases(t, evaluator, successes, nil)
+
+	t.Run("cycles", func(t *testing.T) {
+		type node struct {
+			Next *node
+		}
+		a, b := &node{}, &node{}
+		a.Next, b.Next = a, b
+		s1, s2 := []interface{}{nil, nil}, []interface{}{nil, nil}
+		s1[0], s1[1] = s1, s1
+		s2[0], s2[1] = s2, s2
+		m1, m2 := map[string]interface{}{}, map[string]interface{}{}
+		m1["a"], m1["b"] = m1, m1
+		m2["a"], m2["b"] = m2, m2
+		evaluator := NewInterpreter()
+		evaluator.SetSynchronous(true)
+		evaluator.SetMembers(map[string]interface{}{"a": a, "b": b, "s1": s1, "s2": s2, "m1": m1, "m2": m2})
+		res, err := evaluator.Evaluate(context.Background(), NewParser("@{{ a == b }} @{{ s1 == s2 }} @{{ m1 == m2 }} @{{ s1 == [s1, 1] }} @{{ m1 in [m2] }}").Parse())
+		assert.Nil(t, err)
+		assert.Equal(t, "true true true false true", res)
+	})
+
+	t.Run("failed comparisons", func(t *testing.T) {
+		type point struct{ X int }
+		p1, q := &point{1}, &point{3}
+		// Looking up the keys of left in right may compare p1 with q.
+		left := []interface{}{map[[1]*point]int{{p1}: 1, {&point{3}}: 2}, p1}
+		right := []interface{}{map[[1]interface{}]int{{&point{1}}: 1, {q}: 2}, q}
+		evaluator := NewInterpreter()
+		for i := 0; i < 20; i++ {
+			assert.False(t, evaluator.isEqual(left, right))
+		}
+		assert.Zero(t, testing.AllocsPerRun(10, func() { evaluator.isEqual("a", "a") }))
+	})
+}
+
+func TestExpressionParser(t *testing.T) {
+	evaluator := NewInterpreter()
+	evaluator.SetMembers(createTestTemplateFunctions())
+	expressions := []SuccessCases{
+		{template: `someObject.key == "value" && 4 > 3`, expect: true},
+		{template: `  1 + 2 * 3  `, expect: float64(7)},
+		{template: `{a: {b: 1}}`, expect: map[string]interface{}{"a": map[string]interface{}{"b": float64(1)}}},
+		{template: `"@{{ not a template }}"`, expect: "@{{ not a template }}"},
+	}
+	for _, c := range expressions {
 		t.Run(c.template, func(t *testing.T) {
-			test(t, &c)
+			res, err := evaluator.Evaluate(context.TODO(), ParseExpression(c.template))
+			assert.Nil(t, err)
+			assert.Equal(t, c.expect, res)
+		})
+	}
+
+	expressionErrors := []ErrorCases{
+		{template: `1 2`, msg: "Expect end of expression. got 2"},
+		{template: `1 + 2 }}`, msg: "Expect end of expression. got }"},
+		{template: ``, msg: "Expect expression. got "},
+	}
+	for _, c := range expressionErrors {
+		t.Run(c.template, func(t *testing.T) {
+			_, err := evaluator.Evaluate(context.TODO(), ParseExpression(c.template))
+			assert.ErrorContains(t, err, c.msg)
 		})
 	}
 }
@@ -256,7 +1327,7 @@ func TestExampleParserErrors(t *testing.T) {
 func BenchmarkComplexParser(b *testing.B) {
 	// create a parser with complex expression
 	for n := 0; n < b.N; n++ {
-		NewParser(`2 > 1 &&
+		NewExpressionParser(`2 > 1 &&
 		"something" != "nothing" ||
 		date("2014-01-20") < date("Wed Jul  8 23:07:35 MDT 2015") && 
 		object["Variable name with spaces"] <= array[0] &&
@@ -295,6 +1366,55 @@ func BenchmarkEvaluator(b *testing.B) {
 	}
 }
 
+func BenchmarkSynchronousEvaluator(b *testing.B) {
+	template := `@{{ 
+		2 > 1 &&
+		"this" != "that" ||
+		date("02 Jan 06 15:04 MST").Before(date("03 Jan 06 15:04 MST")) && 
+		object?.["some key"] <= array[0] &&
+		prop + 1000 / 2 > (80 * 100 * 2) 
+	}}`
+	evaluator := NewInterpreter()
+	evaluator.AddMember("object", map[string]interface{}{
+		"some key": 1,
+	})
+	evaluator.AddMember("array", []interface{}{1, 2, 3})
+	evaluator.AddMember("prop", 100)
+	evaluator.AddMember("date", func(s string) (time.Time, error) {
+		return time.Parse(time.RFC822, s)
+	})
+	evaluator.SetTimeout(5 * time.Millisecond)
+	evaluator.SetSynchronous(true)
+	ast := NewParser(template).Parse()
+	for n := 0; n < b.N; n++ {
+		evaluator.Evaluate(context.TODO(), ast)
+	}
+}
+
+func BenchmarkProgram(b *testing.B) {
+	template := `@{{ 
+		2 > 1 &&
+		"this" != "that" ||
+		date("02 Jan 06 15:04 MST").Before(date("03 Jan 06 15:04 MST")) && 
+		object?.["some key"] <= array[0] &&
+		prop + 1000 / 2 > (80 * 100 * 2) 
+	}}`
+	evaluator := NewInterpreter()
+	evaluator.AddMember("object", map[string]interface{}{
+		"some key": 1,
+	})
+	evaluator.AddMember("array", []interface{}{1, 2, 3})
+	evaluator.AddMember("prop", 100)
+	evaluator.AddMember("date", func(s string) (time.Time, error) {
+		return time.Parse(time.RFC822, s)
+	})
+	evaluator.SetTimeout(5 * time.Millisecond)
+	program, _ := evaluator.Compile(NewParser(template).Parse())
+	for n := 0; n < b.N; n++ {
+		program.Run(context.TODO(), nil)
+	}
+}
+
 func createTestTemplateFunctions() map[string]interface{} {
 	return map[string]interface{}{
 		"pointerDummy": &Dummy{},
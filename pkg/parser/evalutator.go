@@ -2,32 +2,87 @@ package parser
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"reflect"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type (
 	Evaluator struct {
-		members map[string]interface{}
-		timeout time.Duration
-		lock    sync.RWMutex
+		members     map[string]interface{}
+		timeout     time.Duration
+		synchronous bool
+		strict      bool
+		limits      Limits
+		policy      AccessPolicy
+		naming      FieldNaming
+		numbers     NumberMode
+		clock       func() time.Time
+		lock        sync.RWMutex
+	}
+
+	// evaluation holds the state of a single call to Evaluate. It is the
+	// Visitor that walks the expression.
+	evaluation struct {
+		*Evaluator
+		usage
+		scope *Scope
+		// inline is set when the evaluation runs on the caller's goroutine.
+		inline *inlineRun
+		// lenient is set while evaluating a lookup that may be undefined in
+		// strict mode. See isLookup.
+		lenient bool
+	}
+
+	// inlineRun enforces the timeout of an evaluation that runs on the
+	// caller's goroutine, without arming a timer unless a function asks for
+	// a context.
+	inlineRun struct {
+		parent   context.Context
+		deadline time.Time
+		ctx      context.Context
+		cancel   context.CancelFunc
 	}
 
 	EvaluationError struct {
 		message string
 	}
+
+	// RuntimeError wraps an error raised while evaluating an expression
+	// with the span of the innermost node that failed.
+	RuntimeError struct {
+		Err  error
+		Span Span
+	}
+
+	// UndefinedError is raised in strict mode when an expression reads a
+	// variable, property or key that does not exist. Path is the expression
+	// as it would be written, such as `user.address.city`.
+	UndefinedError struct {
+		Path string
+	}
 )
 
 const (
 	// DefaultTimeout is the default timeout for evaluating expressions.
 	DefaultTimeout = 10 * time.Millisecond
+
+	// clockInterval is the number of steps between two checks of the clock
+	// by synchronous evaluations.
+	clockInterval = 64
 )
 
 var (
 	EvaluationCancelledErrror = NewEvaluationError("evaluation cancelled")
+
+	// errUndefined is returned by property and index when what they look
+	// up does not exist.
+	errUndefined = errors.New("undefined")
 )
 
 func NewInterpreter() *Evaluator {
@@ -41,6 +96,22 @@ func (i *Evaluator) SetTimeout(timeout time.Duration) {
 	i.timeout = timeout
 }
 
+// SetSynchronous makes Evaluate run on the calling goroutine instead of
+// spawning one per call. The timeout is then enforced cooperatively: the
+// clock is checked every few steps and before each function call, so a
+// function that ignores its context can make an evaluation overrun it.
+func (i *Evaluator) SetSynchronous(synchronous bool) {
+	i.synchronous = synchronous
+}
+
+// SetStrict makes reading an undefined variable, a missing property or a
+// missing key an UndefinedError instead of nil. Lookups can still be
+// undefined on purpose in the chain before `?.` and the property right after
+// it, and in the chain on the left of `??` and `?:`.
+func (i *Evaluator) SetStrict(strict bool) {
+	i.strict = strict
+}
+
 func NewEvaluationError(message string, args ...interface{}) *EvaluationError {
 	return &EvaluationError{message: fmt.Sprintf(message, args...)}
 }
@@ -49,6 +120,23 @@ func (e *EvaluationError) Error() string {
 	return e.message
 }
 
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("Error at %s. %s", e.Span.Start, e.Err)
+}
+
+func (e *UndefinedError) Error() string {
+	return fmt.Sprintf("'%s' is not defined", e.Path)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Format renders the error with the failing part of source underlined.
+func (e *RuntimeError) Format(source string) string {
+	return formatSnippet(source, e.Span, e.Err.Error())
+}
+
 func (i *Evaluator) AddMember(name string, member interface{}) error {
 	i.lock.Lock()
 	defer i.lock.Unlock()
@@ -63,11 +151,14 @@ func (i *Evaluator) SetMembers(members map[string]interface{}) error {
 	return nil
 }
 
-func (i *Evaluator) visitBinaryExpr(ctx context.Context, expr *Binary) EvaluationResult {
+func (i *evaluation) VisitBinaryExpr(ctx context.Context, expr *Binary) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
 
+	if expr.operator.tokenType == NULLCOALESCING || expr.operator.tokenType == FALSY_COALESCING {
+		i.lenient = true
+	}
 	res := i.interpret(ctx, expr.left)
 	if res.Error() != nil {
 		return res
@@ -84,6 +175,10 @@ func (i *Evaluator) visitBinaryExpr(ctx context.Context, expr *Binary) Evaluatio
 			return &result{value: true}
 		}
 	case NULLCOALESCING:
+		if left != nil {
+			return &result{value: left}
+		}
+	case FALSY_COALESCING:
 		if i.isTruthy(left) {
 			return &result{value: left}
 		}
@@ -93,168 +188,240 @@ func (i *Evaluator) visitBinaryExpr(ctx context.Context, expr *Binary) Evaluatio
 	if res.Error() != nil {
 		return res
 	}
-	right := res.Get()
-	switch expr.operator.tokenType {
+	return newResult(i.binary(expr.operator.tokenType, left, res.Get()))
+}
+
+// binary applies operator to the values of the operands of a binary
+// expression. Short-circuiting operators are handled by the caller.
+func (e *Evaluator) binary(operator TokenType, left, right interface{}) (interface{}, error) {
+	switch operator {
 	case MINUS:
-		return i.sub(left, right)
+		return e.sub(left, right)
 	case SLASH:
-		return i.div(left, right)
+		return e.div(left, right)
 	case STAR:
-		return i.mul(left, right)
+		return e.mul(left, right)
+	case PERCENT:
+		return e.mod(left, right)
+	case SLASH_SLASH:
+		return e.intDiv(left, right)
+	case STAR_STAR:
+		return e.pow(left, right)
 	case PLUS:
-		return i.add(left, right)
-	case GREATER:
-		return i.greater(left, right)
-	case GREATER_EQUAL:
-		return i.greaterEqual(left, right)
-	case LESS:
-		return i.less(left, right)
-	case LESS_EQUAL:
-		return i.lessEqual(left, right)
+		return e.add(left, right)
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, IN, NOT_IN:
+		return e.compare(operator, left, right)
 	case BANG_EQUAL:
-		return &result{value: !i.isEqual(left, right)}
+		return !e.isEqual(left, right), nil
 	case EQUAL_EQUAL:
-		return &result{value: i.isEqual(left, right)}
+		return e.isEqual(left, right), nil
 	case AND:
-		return &result{value: i.isTruthy(left) && i.isTruthy(right)}
+		return e.isTruthy(left) && e.isTruthy(right), nil
 	case OR:
-		return &result{value: i.isTruthy(left) || i.isTruthy(right)}
-	case NULLCOALESCING:
-		if i.isTruthy(left) {
-			return &result{value: left}
-		}
-		return &result{value: right}
+		return e.isTruthy(left) || e.isTruthy(right), nil
+	case NULLCOALESCING, FALSY_COALESCING:
+		return right, nil
 	}
-	return &result{}
+	return nil, nil
 }
 
-func (e *Evaluator) add(left, right interface{}) EvaluationResult {
+func (e *Evaluator) add(left, right interface{}) (interface{}, error) {
 	if left == nil || right == nil {
-		return &result{err: fmt.Errorf("cannot add nil values: adding %v and %v", left, right)}
+		return nil, fmt.Errorf("cannot add nil values: adding %v and %v", left, right)
 	}
 
 	switch l := left.(type) {
 	case string:
 		if r, ok := right.(string); ok {
-			return &result{value: l + r}
+			if err := e.limits.checkString(l + r); err != nil {
+				return nil, err
+			}
+			return l + r, nil
 		}
 	}
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		return &result{value: leftNum + rightNum}
+	if value, ok, err := e.temporal(PLUS, left, right); ok {
+		return value, err
+	}
+	if value, ok, err := e.arithmetic(PLUS, left, right); ok {
+		return value, err
 	}
 
-	return &result{err: fmt.Errorf("cannot add non-numbers or strings: %v + %v", left, right)}
+	return nil, fmt.Errorf("cannot add non-numbers or strings: %v + %v", left, right)
 }
 
-func (e *Evaluator) sub(left, right interface{}) EvaluationResult {
+func (e *Evaluator) sub(left, right interface{}) (interface{}, error) {
 	if left == nil || right == nil {
-		return &result{err: fmt.Errorf("cannot subtract nil values: adding %v and %v", left, right)}
+		return nil, fmt.Errorf("cannot subtract nil values: adding %v and %v", left, right)
 	}
 
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		return &result{value: leftNum - rightNum}
+	if value, ok, err := e.temporal(MINUS, left, right); ok {
+		return value, err
+	}
+	if value, ok, err := e.arithmetic(MINUS, left, right); ok {
+		return value, err
 	}
-	return &result{err: fmt.Errorf("cannot subtract non-numbers or strings: %v - %v", left, right)}
+	return nil, fmt.Errorf("cannot subtract non-numbers or strings: %v - %v", left, right)
 }
 
-func (e *Evaluator) mul(left, right interface{}) EvaluationResult {
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		return &result{value: leftNum * rightNum}
+func (e *Evaluator) mul(left, right interface{}) (interface{}, error) {
+	if value, ok, err := e.temporal(STAR, left, right); ok {
+		return value, err
 	}
-	return &result{err: fmt.Errorf("cannot multiply non-numbers: %v * %v", left, right)}
+	if value, ok, err := e.arithmetic(STAR, left, right); ok {
+		return value, err
+	}
+	return nil, fmt.Errorf("cannot multiply non-numbers: %v * %v", left, right)
 }
 
-func (e *Evaluator) div(left, right interface{}) EvaluationResult {
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		if rightNum == 0 {
-			return &result{err: fmt.Errorf("cannot divide by zero: %f / %f", leftNum, rightNum)}
+func (e *Evaluator) div(left, right interface{}) (interface{}, error) {
+	if value, ok, err := e.temporal(SLASH, left, right); ok {
+		return value, err
+	}
+	if value, ok, err := e.arithmetic(SLASH, left, right); ok {
+		return value, err
+	}
+	return nil, fmt.Errorf("cannot divide non-numbers: %v / %v", left, right)
+}
+
+// mod returns the remainder of left / right. Like javascript, the result
+// takes the sign of the dividend.
+func (e *Evaluator) mod(left, right interface{}) (interface{}, error) {
+	if value, ok, err := e.arithmetic(PERCENT, left, right); ok {
+		return value, err
+	}
+	return nil, fmt.Errorf("cannot compute modulo of non-numbers: %v %% %v", left, right)
+}
+
+// intDiv divides left by right and rounds the quotient down to the nearest integer.
+func (e *Evaluator) intDiv(left, right interface{}) (interface{}, error) {
+	if value, ok, err := e.arithmetic(SLASH_SLASH, left, right); ok {
+		return value, err
+	}
+	return nil, fmt.Errorf("cannot divide non-numbers: %v // %v", left, right)
+}
+
+func (e *Evaluator) pow(left, right interface{}) (interface{}, error) {
+	if value, ok, err := e.arithmetic(STAR_STAR, left, right); ok {
+		return value, err
+	}
+	return nil, fmt.Errorf("cannot exponentiate non-numbers: %v ** %v", left, right)
+}
+func (e *Evaluator) compare(operator TokenType, left, right interface{}) (interface{}, error) {
+	switch operator {
+	case GREATER:
+		return e.greater(left, right)
+	case GREATER_EQUAL:
+		return e.greaterEqual(left, right)
+	case LESS:
+		return e.less(left, right)
+	case LESS_EQUAL:
+		return e.lessEqual(left, right)
+	case IN:
+		return e.contains(right, left)
+	case NOT_IN:
+		contains, err := e.contains(right, left)
+		if err != nil {
+			return nil, err
 		}
-		return &result{value: leftNum / rightNum}
+		return !contains.(bool), nil
 	}
-	return &result{err: fmt.Errorf("cannot divide non-numbers: %v / %v", left, right)}
+	return nil, fmt.Errorf("unknown comparison operator %s", operator)
 }
-func (e *Evaluator) greater(left, right interface{}) EvaluationResult {
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		return &result{value: leftNum > rightNum}
+
+func (e *Evaluator) greater(left, right interface{}) (interface{}, error) {
+	if isNumber(left) && isNumber(right) {
+		cmp, ok := e.compareNumbers(left, right)
+		return ok && cmp > 0, nil
+	}
+	if cmp, ok := compareTimes(left, right); ok {
+		return cmp > 0, nil
 	}
 	switch l := left.(type) {
 	case string:
 		if r, ok := right.(string); ok {
-			return &result{value: l > r}
+			return l > r, nil
 		}
 	}
-	return &result{err: fmt.Errorf("cannot compare %T with %T", left, right)}
+	return nil, fmt.Errorf("cannot compare %T with %T", left, right)
 }
 
-func (e *Evaluator) greaterEqual(left, right interface{}) EvaluationResult {
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		return &result{value: leftNum >= rightNum}
+func (e *Evaluator) greaterEqual(left, right interface{}) (interface{}, error) {
+	if isNumber(left) && isNumber(right) {
+		cmp, ok := e.compareNumbers(left, right)
+		return ok && cmp >= 0, nil
+	}
+	if cmp, ok := compareTimes(left, right); ok {
+		return cmp >= 0, nil
 	}
 	switch l := left.(type) {
 	case string:
 		if r, ok := right.(string); ok {
-			return &result{value: l >= r}
+			return l >= r, nil
 		}
 	}
-	return &result{err: fmt.Errorf("cannot compare %T with %T", left, right)}
+	return nil, fmt.Errorf("cannot compare %T with %T", left, right)
 }
 
-func (e *Evaluator) less(left, right interface{}) EvaluationResult {
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		return &result{value: leftNum < rightNum}
+func (e *Evaluator) less(left, right interface{}) (interface{}, error) {
+	if isNumber(left) && isNumber(right) {
+		cmp, ok := e.compareNumbers(left, right)
+		return ok && cmp < 0, nil
+	}
+	if cmp, ok := compareTimes(left, right); ok {
+		return cmp < 0, nil
 	}
 	switch l := left.(type) {
 	case string:
 		if r, ok := right.(string); ok {
-			return &result{value: l < r}
+			return l < r, nil
 		}
 	}
-	return &result{err: fmt.Errorf("cannot compare %T with %T", left, right)}
+	return nil, fmt.Errorf("cannot compare %T with %T", left, right)
 }
 
-func (e *Evaluator) lessEqual(left, right interface{}) EvaluationResult {
-	if areNumbers(left, right) {
-		leftNum, _ := toFloat64(left)
-		rightNum, _ := toFloat64(right)
-		return &result{value: leftNum <= rightNum}
+func (e *Evaluator) lessEqual(left, right interface{}) (interface{}, error) {
+	if isNumber(left) && isNumber(right) {
+		cmp, ok := e.compareNumbers(left, right)
+		return ok && cmp <= 0, nil
+	}
+	if cmp, ok := compareTimes(left, right); ok {
+		return cmp <= 0, nil
 	}
 	switch l := left.(type) {
 	case string:
 		if r, ok := right.(string); ok {
-			return &result{value: l <= r}
+			return l <= r, nil
 		}
 	}
-	return &result{err: fmt.Errorf("cannot compare %T with %T", left, right)}
+	return nil, fmt.Errorf("cannot compare %T with %T", left, right)
 }
 
-func (i *Evaluator) isEqual(a, b interface{}) bool {
-	if a == nil && b == nil {
-		return true
-	}
-	if a == nil {
-		return false
+// contains reports whether item is an element of a slice or array, a key of
+// a map or a substring of a string.
+func (e *Evaluator) contains(collection, item interface{}) (interface{}, error) {
+	if str, ok := collection.(string); ok {
+		if sub, ok := item.(string); ok {
+			return strings.Contains(str, sub), nil
+		}
+		return nil, fmt.Errorf("cannot check if %T is in string", item)
 	}
-	if b == nil {
-		return false
+	value := reflect.ValueOf(collection)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for index := 0; index < value.Len(); index++ {
+			if e.isEqual(value.Index(index).Interface(), item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		key, ok := mapKey(value, item)
+		return ok && value.MapIndex(key).IsValid(), nil
 	}
-	return a == b
+	return nil, fmt.Errorf("cannot check membership in %T", collection)
 }
 
-func (i *Evaluator) visitParseErrorExpr(
+func (i *evaluation) VisitParseErrorExpr(
 	ctx context.Context,
 	expr *ParseError,
 ) EvaluationResult {
@@ -264,21 +431,21 @@ func (i *Evaluator) visitParseErrorExpr(
 	return &result{err: fmt.Errorf("parse error: %w", expr)}
 }
 
-func (i *Evaluator) visitGroupingExpr(ctx context.Context, expr *Grouping) EvaluationResult {
+func (i *evaluation) VisitGroupingExpr(ctx context.Context, expr *Grouping) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
 	return i.interpret(ctx, expr.expression)
 }
 
-func (i *Evaluator) visitLiteralExpr(ctx context.Context, expr *Literal) EvaluationResult {
+func (i *evaluation) VisitLiteralExpr(ctx context.Context, expr *Literal) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
-	return &result{value: expr.value}
+	return &result{value: i.literal(expr)}
 }
 
-func (i *Evaluator) visitUnaryExpr(ctx context.Context, expr *Unary) EvaluationResult {
+func (i *evaluation) VisitUnaryExpr(ctx context.Context, expr *Unary) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
@@ -286,17 +453,20 @@ func (i *Evaluator) visitUnaryExpr(ctx context.Context, expr *Unary) EvaluationR
 	if res.Error() != nil {
 		return res
 	}
-	right := res.Get()
-	switch expr.operator.tokenType {
+	return newResult(i.unary(expr.operator.tokenType, res.Get()))
+}
+
+func (e *Evaluator) unary(operator TokenType, right interface{}) (interface{}, error) {
+	switch operator {
 	case MINUS:
-		return &result{value: -(right.(float64))} // TODO: handle other numeric types
-	case BANG:
-		return &result{value: !(i.isTruthy(right))}
+		return e.negate(right)
+	case BANG, NOT:
+		return !(e.isTruthy(right)), nil
 	}
-	return &result{}
+	return nil, nil
 }
 
-func (i *Evaluator) visitTemplateExpr(ctx context.Context, expr *Template) EvaluationResult {
+func (i *evaluation) VisitTemplateExpr(ctx context.Context, expr *Template) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
@@ -308,18 +478,24 @@ func (i *Evaluator) visitTemplateExpr(ctx context.Context, expr *Template) Evalu
 		}
 		evaluations = append(evaluations, res.Get())
 	}
+	return newResult(i.join(evaluations))
+}
+
+// join concatenates the values of the parts of a template. A template made
+// of a single part evaluates to the value of that part.
+func (e *Evaluator) join(evaluations []interface{}) (interface{}, error) {
 	if len(evaluations) == 1 {
-		return &result{value: evaluations[0]}
+		return evaluations[0], e.limits.checkOutput(evaluations[0])
 	}
 
 	str := strings.Builder{}
 	for _, e := range evaluations {
-		str.WriteString(fmt.Sprintf("%v", e))
+		str.WriteString(stringify(e))
 	}
-	return &result{value: str.String()}
+	return str.String(), e.limits.checkOutput(str.String())
 }
 
-func (i *Evaluator) visitTernaryExpr(ctx context.Context, expr *Ternary) EvaluationResult {
+func (i *evaluation) VisitTernaryExpr(ctx context.Context, expr *Ternary) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
@@ -333,20 +509,34 @@ func (i *Evaluator) visitTernaryExpr(ctx context.Context, expr *Ternary) Evaluat
 	return i.interpret(ctx, expr.falseExpr)
 }
 
-func (i *Evaluator) visitVariableExpr(ctx context.Context, expr *Variable) EvaluationResult {
+func (i *evaluation) VisitVariableExpr(ctx context.Context, expr *Variable) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
-	if member, ok := i.members[expr.name.lexeme]; ok {
-		return &result{value: member}
+	value, ok := i.lookup(i.scope, expr.name.lexeme)
+	if !ok && i.strict && !i.lenient {
+		return &result{err: &UndefinedError{Path: expr.name.lexeme}}
+	}
+	return &result{value: value}
+}
+
+// lookup returns the value of the variable called name in scope, falling
+// back to the members of the evaluator and then to the built-in functions.
+func (e *Evaluator) lookup(scope *Scope, name string) (interface{}, bool) {
+	if value, ok := scope.Lookup(name); ok {
+		return value, true
+	}
+	if value, ok := e.members[name]; ok {
+		return value, true
 	}
-	return &result{}
+	return e.builtin(name)
 }
 
-func (i *Evaluator) visitOptionalExpr(ctx context.Context, expr *Optional) EvaluationResult {
+func (i *evaluation) VisitOptionalExpr(ctx context.Context, expr *Optional) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
+	i.lenient = true
 	res := i.interpret(ctx, expr.left)
 	if res.Error() != nil {
 		return res
@@ -360,10 +550,11 @@ func (i *Evaluator) visitOptionalExpr(ctx context.Context, expr *Optional) Evalu
 	return res
 }
 
-func (i *Evaluator) visitGetExpr(ctx context.Context, expr *Get) EvaluationResult {
+func (i *evaluation) VisitGetExpr(ctx context.Context, expr *Get) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
+	lenient := i.lenient || isOptional(expr.object)
 	res := i.interpret(ctx, expr.object)
 	if res.Error() != nil {
 		return res
@@ -371,42 +562,68 @@ func (i *Evaluator) visitGetExpr(ctx context.Context, expr *Get) EvaluationResul
 	if res, ok := res.(*optionalEvaluationResult); ok && res.IsAbsent() {
 		return res
 	}
-	obj := res.Get()
+	value, err := i.property(res.Get(), expr.name.lexeme)
+	return newResult(value, i.undefined(err, expr, lenient))
+}
+
+// undefined turns the errUndefined returned by a lookup of expr into an
+// UndefinedError in strict mode, or into nil otherwise.
+func (e *Evaluator) undefined(err error, expr Expr, lenient bool) error {
+	if err != errUndefined {
+		return err
+	}
+	if !e.strict || lenient {
+		return nil
+	}
+	path, _ := Print(expr)
+	return &UndefinedError{Path: path}
+}
+
+// property returns the property called name of obj.
+func (e *Evaluator) property(obj interface{}, name string) (interface{}, error) {
 	if obj == nil {
-		return &result{err: fmt.Errorf("cannot get property '%s' of nil", expr.name.lexeme)}
+		return nil, fmt.Errorf("cannot get property '%s' of nil", name)
 	}
 
 	value := reflect.ValueOf(obj) // hack for pointer receivers
 
 	switch value.Kind() {
 	case reflect.Map:
-		key := reflect.ValueOf(expr.name.lexeme)
-		if value.MapIndex(key).IsValid() {
-			return &result{value: value.MapIndex(key).Interface()}
+		if key, ok := mapKey(value, name); ok && value.MapIndex(key).IsValid() {
+			return value.MapIndex(key).Interface(), nil
 		}
-		return &result{} // TODO: return error?
+		if method, ok := mapMethods[name]; ok {
+			return e.bindMethod(value, name, method), nil
+		}
+		return nil, errUndefined
 	case reflect.Struct, reflect.Ptr:
-		if field, ok := getFieldFromStructOrPointer(value, expr.name.lexeme); ok {
-			return &result{value: field}
+		return e.member(value, name)
+	case reflect.Slice, reflect.Array:
+		if name == "length" {
+			return e.integer(value.Len()), nil
+		}
+		if method, ok := arrayMethods[name]; ok {
+			return e.bindMethod(value, name, method), nil
 		}
-		if method, ok := getMethodFromStructOrPointer(value, expr.name.lexeme); ok {
-			return &result{value: method}
+		return nil, fmt.Errorf("property '%s' does not exist", name)
+	case reflect.String:
+		if name == "length" {
+			return e.integer(utf8.RuneCountInString(value.String())), nil
 		}
-		return &result{} // TODO: return error?
-	case reflect.Slice, reflect.Array, reflect.String:
-		if expr.name.lexeme == "length" {
-			return &result{value: float64(value.Len())}
+		if method, ok := stringMethods[name]; ok {
+			return e.bindMethod(value, name, method), nil
 		}
-		return &result{err: fmt.Errorf("property '%s' does not exist", expr.name.lexeme)}
+		return nil, fmt.Errorf("property '%s' does not exist", name)
 	default:
-		return &result{err: fmt.Errorf("cannot get property '%s' of type %T", expr.name.lexeme, obj)}
+		return nil, fmt.Errorf("cannot get property '%s' of type %T", name, obj)
 	}
 }
 
-func (i *Evaluator) visitIndexExpr(ctx context.Context, expr *Index) EvaluationResult {
+func (i *evaluation) VisitIndexExpr(ctx context.Context, expr *Index) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
+	lenient := i.lenient || isOptional(expr.object)
 	res := i.interpret(ctx, expr.object)
 	if res.Error() != nil {
 		return res
@@ -415,54 +632,61 @@ func (i *Evaluator) visitIndexExpr(ctx context.Context, expr *Index) EvaluationR
 		return res
 	}
 	obj := res.Get()
+	i.lenient = false
 	res = i.interpret(ctx, expr.index)
 	if res.Error() != nil {
 		return res
 	}
-	indexValue := res.Get()
+	value, err := i.index(obj, res.Get())
+	return newResult(value, i.undefined(err, expr, lenient))
+}
 
+// index returns the element of obj at indexValue.
+func (e *Evaluator) index(obj, indexValue interface{}) (interface{}, error) {
 	if obj == nil {
-		return &result{err: fmt.Errorf("cannot index into nil")}
+		return nil, fmt.Errorf("cannot index into nil")
 	}
 
 	value := reflect.ValueOf(obj)
 
 	switch value.Kind() {
 	case reflect.Map:
-		key := reflect.ValueOf(indexValue)
-		if value.MapIndex(key).IsValid() {
-			return &result{value: value.MapIndex(key).Interface()}
+		if key, ok := mapKey(value, indexValue); ok && value.MapIndex(key).IsValid() {
+			return value.MapIndex(key).Interface(), nil
 		}
-		return &result{} // TODO: return error?
+		return nil, errUndefined
 	case reflect.Struct, reflect.Ptr:
 		key, ok := indexValue.(string)
 		if !ok {
-			return &result{err: fmt.Errorf("property '%s' does not exist", indexValue)}
+			return nil, fmt.Errorf("property '%s' does not exist", indexValue)
 		}
-		if field, ok := getFieldFromStructOrPointer(value, key); ok {
-			return &result{value: field}
+		return e.member(value, key)
+	case reflect.Slice, reflect.Array:
+		index, ok := toInt(indexValue)
+		if !ok {
+			return nil, fmt.Errorf("index '%v' is not an integer", indexValue)
 		}
-		if method, ok := getMethodFromStructOrPointer(value, key); ok {
-			return &result{value: method}
+		if index < 0 || index >= value.Len() {
+			return nil, fmt.Errorf("index '%v' is out of bounds", indexValue)
 		}
-
-		return &result{} // TODO: return error?
-	case reflect.Slice, reflect.Array, reflect.String:
-		indexFloat, ok := indexValue.(float64)
+		return value.Index(index).Interface(), nil
+	case reflect.String:
+		// Strings are indexed by character, as by charAt, not by byte.
+		index, ok := toInt(indexValue)
 		if !ok {
-			return &result{err: fmt.Errorf("index '%v' is not an integer", indexValue)}
+			return nil, fmt.Errorf("index '%v' is not an integer", indexValue)
 		}
-		index := int(indexFloat)
-		if index < 0 || index >= value.Len() {
-			return &result{err: fmt.Errorf("index '%v' is out of bounds", indexValue)}
+		runes := []rune(value.String())
+		if index < 0 || index >= len(runes) {
+			return nil, fmt.Errorf("index '%v' is out of bounds", indexValue)
 		}
-		return &result{value: value.Index(index).Interface()}
+		return string(runes[index]), nil
 	default:
-		return &result{err: fmt.Errorf("cannot index into type %T", obj)}
+		return nil, fmt.Errorf("cannot index into type %T", obj)
 	}
 }
 
-func (e *Evaluator) visitCallExpr(ctx context.Context, expr *Call) EvaluationResult {
+func (e *evaluation) VisitCallExpr(ctx context.Context, expr *Call) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
@@ -474,53 +698,73 @@ func (e *Evaluator) visitCallExpr(ctx context.Context, expr *Call) EvaluationRes
 	if calleeRes, ok := calleeRes.(*optionalEvaluationResult); ok && calleeRes.IsAbsent() {
 		return calleeRes
 	}
-	callee := calleeRes.Get()
+	name := identifyCallee(expr)
+	fn, err := e.function(calleeRes.Get(), name)
+	if err != nil {
+		return &result{err: err}
+	}
+
+	args := make([]interface{}, 0)
+	for _, a := range expr.arguments {
+		res := e.interpret(ctx, a)
+		if res.Error() != nil {
+			return res
+		}
+		args = append(args, res.Get())
+	}
+	if e.inline != nil && e.inline.err() != nil {
+		return &result{err: EvaluationCancelledErrror}
+	}
+	return newResult(e.call(e.callContext(ctx), fn, name, args))
+}
+
+// function checks that callee is a function that can be called from an
+// expression. name identifies the callee in errors.
+func (e *Evaluator) function(callee interface{}, name string) (reflect.Value, error) {
+	if closure, ok := callee.(*Closure); ok {
+		return reflect.ValueOf(closure.Call), nil
+	}
 	fn := reflect.ValueOf(callee)
 	if fn.Kind() != reflect.Func {
-		return &result{err: NewEvaluationError(
+		return fn, NewEvaluationError(
 			"cannot call non-function '%s' of type %T",
-			identifyCallee(expr),
+			name,
 			callee,
-		)}
+		)
 	}
 	if fn.Type().NumOut() > 2 {
-		return &result{err: NewEvaluationError(
+		return fn, NewEvaluationError(
 			"function '%s' returns more than 2 values",
-			identifyCallee(expr),
-		)}
+			name,
+		)
 	}
 	if fn.Type().NumOut() == 2 {
-		if fn.Type().Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
-			return &result{err: NewEvaluationError(
+		if fn.Type().Out(1) != errorType {
+			return fn, NewEvaluationError(
 				"function '%s' second return value must be of type error",
-				identifyCallee(expr),
-			)}
+				name,
+			)
 		}
 	}
+	return fn, nil
+}
 
-	args := make([]interface{}, 0)
-	for _, a := range expr.arguments {
-		res := e.interpret(ctx, a)
-		if res.Error() != nil {
-			return res
-		}
-		args = append(args, res.Get())
-	}
-
+// call calls fn with args, passing ctx first if fn accepts a context.
+func (e *Evaluator) call(ctx context.Context, fn reflect.Value, name string, args []interface{}) (value interface{}, err error) {
 	isVariadic := fn.Type().IsVariadic()
 	var argIndex int
 	in := make([]reflect.Value, 0)
-	if fn.Type().NumIn() > 0 && fn.Type().In(0) == reflect.TypeOf((*context.Context)(nil)).Elem() {
-		in = append(in, reflect.ValueOf(ctx))
+	if takesContext(fn.Type()) {
+		in = append(in, reflect.ValueOf(context.WithValue(ctx, evaluatorKey{}, e)))
 		argIndex = 1
 	}
 	if !isVariadic && fn.Type().NumIn() != (len(args)+argIndex) {
-		return &result{err: NewEvaluationError(
+		return nil, NewEvaluationError(
 			"function '%s' expects %d arguments, got %d",
-			identifyCallee(expr),
+			name,
 			fn.Type().NumIn()-argIndex,
 			len(args),
-		)}
+		)
 	}
 
 	variadicIndex := fn.Type().NumIn() - 1
@@ -530,48 +774,65 @@ func (e *Evaluator) visitCallExpr(ctx context.Context, expr *Call) EvaluationRes
 			varsType := fn.Type().In(variadicIndex)
 			paramType := varsType.Elem()
 			for _, a := range args[i:] {
-				if !reflect.TypeOf(a).AssignableTo(paramType) {
-					return &result{err: NewEvaluationError(
+				argValue, ok := e.argument(ctx, a, paramType)
+				if !ok {
+					return nil, NewEvaluationError(
 						"variadic argument '%v' is not assignable to type '%s'",
-						arg,
+						a,
 						paramType.String(),
-					)}
+					)
 				}
-				in = append(in, reflect.ValueOf(a))
+				in = append(in, argValue)
 			}
 			break
 		}
-		argValue := reflect.ValueOf(arg)
 		paramType := fn.Type().In(i + argIndex)
-
-		if !argValue.Type().AssignableTo(paramType) {
-			// attempt to convert arg to paramType
-			if argValue.Type().ConvertibleTo(paramType) {
-				argValue = argValue.Convert(paramType)
-			} else {
-				return &result{err: NewEvaluationError(
-					"argument '%v' is not assignable to parameter '%s'",
-					arg,
-					paramType.String(),
-				)}
-			}
+		argValue, ok := e.argument(ctx, arg, paramType)
+		if !ok {
+			return nil, NewEvaluationError(
+				"argument '%v' is not assignable to parameter '%s'",
+				arg,
+				paramType.String(),
+			)
 		}
 
 		in = append(in, argValue)
 	}
 
+	defer func() {
+		if r := recover(); r != nil {
+			closureErr, ok := r.(*closureError)
+			if !ok {
+				panic(r)
+			}
+			value, err = nil, closureErr.err
+		}
+	}()
 	out := fn.Call(in)
 	if len(out) == 2 {
 		if out[1].Interface() != nil {
-			return &result{err: out[1].Interface().(error)}
+			return nil, out[1].Interface().(error)
 		}
 	}
-	return &result{value: out[0].Interface()}
+	if str, ok := out[0].Interface().(string); ok {
+		return str, e.limits.checkString(str)
+	}
+	return out[0].Interface(), nil
+}
+
+// takesContext reports whether a function of type fn expects a context as
+// its first argument.
+func takesContext(fn reflect.Type) bool {
+	return fn.NumIn() > 0 && fn.In(0) == contextType
 }
-func (i *Evaluator) visitArrayExpr(ctx context.Context, expr *Array) EvaluationResult {
+
+func (i *evaluation) VisitArrayExpr(ctx context.Context, expr *Array) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
+	if err := i.limits.checkCollection(len(expr.values)); err != nil {
+		return &result{err: err}
+	}
 	values := make([]interface{}, len(expr.values))
 	for index, v := range expr.values {
 		res := i.interpret(ctx, v)
@@ -583,10 +844,37 @@ func (i *Evaluator) visitArrayExpr(ctx context.Context, expr *Array) EvaluationR
 	return &result{value: values}
 }
 
-func (i *Evaluator) visitMapExpr(ctx context.Context, expr *Map) EvaluationResult {
+func (i *evaluation) VisitComparisonExpr(ctx context.Context, expr *Comparison) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
+	res := i.interpret(ctx, expr.operands[0])
+	if res.Error() != nil {
+		return res
+	}
+	left := res.Get()
+	for index, operator := range expr.operators {
+		res = i.interpret(ctx, expr.operands[index+1])
+		if res.Error() != nil {
+			return res
+		}
+		right := res.Get()
+		holds, err := i.compare(operator.tokenType, left, right)
+		if err != nil || !i.isTruthy(holds) {
+			return newResult(holds, err)
+		}
+		left = right
+	}
+	return &result{value: true}
+}
+
+func (i *evaluation) VisitMapExpr(ctx context.Context, expr *Map) EvaluationResult {
+	if ctx.Err() != nil {
+		return &result{err: EvaluationCancelledErrror}
+	}
+	if err := i.limits.checkCollection(len(expr.entries)); err != nil {
+		return &result{err: err}
+	}
 	m := make(map[string]interface{})
 	for _, e := range expr.entries {
 		var entry [2]interface{}
@@ -597,12 +885,12 @@ func (i *Evaluator) visitMapExpr(ctx context.Context, expr *Map) EvaluationResul
 		entry = res.Get().([2]interface{})
 		key := entry[0]
 		value := entry[1]
-		m[fmt.Sprintf("%v", key)] = value
+		m[stringify(key)] = value
 	}
 	return &result{value: m}
 }
 
-func (i *Evaluator) visitMapEntryExpr(ctx context.Context, expr *MapEntry) EvaluationResult {
+func (i *evaluation) VisitMapEntryExpr(ctx context.Context, expr *MapEntry) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
@@ -653,6 +941,9 @@ func toFloat64(value interface{}) (float64, error) {
 		return float64(v), nil
 	case float64:
 		return v, nil
+	case *big.Rat:
+		number, _ := v.Float64()
+		return number, nil
 	default:
 		return 0, fmt.Errorf("not a number")
 	}
@@ -660,36 +951,13 @@ func toFloat64(value interface{}) (float64, error) {
 
 func isNumber(value interface{}) bool {
 	switch value.(type) {
-	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, *big.Rat:
 		return true
 	default:
 		return false
 	}
 }
 
-func areNumbers(values ...interface{}) bool {
-	for _, v := range values {
-		if !isNumber(v) {
-			return false
-		}
-	}
-	return true
-}
-
-func getFieldFromStructOrPointer(value reflect.Value, name string) (interface{}, bool) {
-	switch value.Kind() {
-	case reflect.Ptr:
-		value = value.Elem()
-	case reflect.Struct:
-	default:
-		return nil, false
-	}
-	if field := value.FieldByName(name); field.IsValid() {
-		return field.Interface(), true
-	}
-	return nil, false
-}
-
 func getMethodFromStructOrPointer(value reflect.Value, name string) (interface{}, bool) {
 	if method := value.MethodByName(name); method.IsValid() {
 		return method.Interface(), true
@@ -722,8 +990,24 @@ func (i *Evaluator) isTruthy(object interface{}) bool {
 }
 
 func (i *Evaluator) Evaluate(ctx context.Context, expr Expr) (interface{}, error) {
+	return i.EvaluateIn(ctx, expr, nil)
+}
+
+// EvaluateWith evaluates expr with vars layered over the members of the
+// evaluator, so that per-request data does not have to be added to a
+// shared evaluator.
+func (i *Evaluator) EvaluateWith(ctx context.Context, expr Expr, vars map[string]interface{}) (interface{}, error) {
+	return i.EvaluateIn(ctx, expr, NewScope(nil, vars))
+}
+
+// EvaluateIn evaluates expr with the variables of scope and its parents
+// layered over the members of the evaluator. scope can be nil.
+func (i *Evaluator) EvaluateIn(ctx context.Context, expr Expr, scope *Scope) (interface{}, error) {
 	i.lock.RLock()
 	defer i.lock.RUnlock()
+	if i.synchronous {
+		return i.evaluateInline(ctx, expr, scope)
+	}
 	ctx, cancel := context.WithTimeout(ctx, i.timeout)
 	defer cancel()
 
@@ -742,7 +1026,7 @@ func (i *Evaluator) Evaluate(ctx context.Context, expr Expr) (interface{}, error
 			}
 			close(done)
 		}()
-		r := i.interpret(ctx, expr)
+		r := (&evaluation{Evaluator: i, scope: scope}).interpret(ctx, expr)
 		once.Do(func() {
 			result = r.Get()
 			err = r.Error()
@@ -757,9 +1041,119 @@ func (i *Evaluator) Evaluate(ctx context.Context, expr Expr) (interface{}, error
 	}
 }
 
-func (i *Evaluator) interpret(ctx context.Context, expr Expr) EvaluationResult {
+func (i *Evaluator) evaluateInline(ctx context.Context, expr Expr, scope *Scope) (value interface{}, err error) {
+	inline := newInlineRun(ctx, i.timeout)
+	defer func() {
+		inline.stop()
+		if r := recover(); r != nil {
+			value, err = nil, NewEvaluationError("%v", r)
+		}
+	}()
+
+	res := (&evaluation{Evaluator: i, scope: scope, inline: inline}).interpret(ctx, expr)
+	if ctxErr := inline.err(); ctxErr != nil {
+		return nil, NewEvaluationError("evaluation canceled: %s", ctxErr.Error())
+	}
+	if res.Error() != nil {
+		return nil, res.Error()
+	}
+	return res.Get(), nil
+}
+
+func (i *evaluation) interpret(ctx context.Context, expr Expr) EvaluationResult {
 	if ctx.Err() != nil {
 		return &result{err: EvaluationCancelledErrror}
 	}
-	return expr.Accept(ctx, i)
+	if err := i.enter(i.limits); err != nil {
+		return &result{err: locate(err, expr.Span())}
+	}
+	defer i.leave()
+	// Synchronous evaluations check the clock every clockInterval steps.
+	if i.inline != nil && i.steps%clockInterval == 0 && i.inline.err() != nil {
+		return &result{err: EvaluationCancelledErrror}
+	}
+	lenient := i.lenient && isLookup(expr)
+	i.lenient = lenient
+	res := expr.Accept(ctx, i)
+	i.lenient = false
+	if err := res.Error(); err != nil && shouldLocate(err) {
+		return &result{err: locate(err, expr.Span())}
+	}
+	return res
+}
+
+// isLookup reports whether expr is part of a chain of lookups. Leniency set
+// for an expression only spreads to the lookups its value comes from, not
+// to indexes or arguments.
+func isLookup(expr Expr) bool {
+	switch expr.(type) {
+	case *Variable, *Get, *Index, *Optional, *Grouping:
+		return true
+	}
+	return false
+}
+
+func isOptional(expr Expr) bool {
+	_, ok := expr.(*Optional)
+	return ok
+}
+
+// callContext returns the context passed to a function called from ctx.
+func (i *evaluation) callContext(ctx context.Context) context.Context {
+	if i.inline == nil {
+		return ctx
+	}
+	return i.inline.context()
+}
+
+func newInlineRun(parent context.Context, timeout time.Duration) *inlineRun {
+	return &inlineRun{parent: parent, deadline: time.Now().Add(timeout)}
+}
+
+// context returns the parent context with the deadline of the run.
+func (r *inlineRun) context() context.Context {
+	if r.ctx == nil {
+		r.ctx, r.cancel = context.WithDeadline(r.parent, r.deadline)
+	}
+	return r.ctx
+}
+
+// err returns the reason the run was cancelled, if it was.
+func (r *inlineRun) err() error {
+	if r.ctx != nil && r.ctx.Err() != nil {
+		return r.ctx.Err()
+	}
+	// The timer of ctx may not have fired yet when the deadline is reached.
+	if err := r.parent.Err(); err != nil {
+		return err
+	}
+	if !time.Now().Before(r.deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func (r *inlineRun) stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// locate wraps err in a RuntimeError at span, unless it was already located.
+func locate(err error, span Span) error {
+	if err == nil || !shouldLocate(err) {
+		return err
+	}
+	return &RuntimeError{Err: err, Span: span}
+}
+
+// shouldLocate reports whether err still needs to be wrapped in a
+// RuntimeError. Errors are located by the innermost node that fails, and
+// cancellations and parse errors carry no evaluation position.
+func shouldLocate(err error) bool {
+	var runtimeErr *RuntimeError
+	var parseErr *ParseError
+	return err != EvaluationCancelledErrror &&
+		!errors.As(err, &runtimeErr) &&
+		!errors.As(err, &parseErr)
 }
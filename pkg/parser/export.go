@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"time"
+)
+
+// exports records the slices, maps and pointers being exported, to refuse
+// cyclic values.
+type exports map[visit]bool
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// Export converts value into values that expose no more than expressions
+// can reach, for functions that hand values over to code that reads them
+// through reflection, such as encoders. Structs, and pointers to them,
+// become maps keyed by the names FieldNaming gives their fields, without the
+// fields hidden by tags or denied by the access policy. Arrays, slices and
+// maps are converted element by element, and numbers, strings, booleans and
+// times are kept. Other values, such as functions, lambdas and cyclic
+// values, cannot be exported.
+func (i *Evaluator) Export(value interface{}) (interface{}, error) {
+	return i.export(reflect.ValueOf(value), exports{})
+}
+
+func (i *Evaluator) export(value reflect.Value, seen exports) (interface{}, error) {
+	if !value.IsValid() {
+		return nil, nil
+	}
+	switch value.Interface().(type) {
+	case time.Time, *big.Rat:
+		return value.Interface(), nil
+	case *Closure:
+		return nil, fmt.Errorf("cannot export %s", value.Type())
+	}
+	if basicKind(value.Kind()) {
+		return value.Interface(), nil
+	}
+	switch value.Kind() {
+	case reflect.Interface:
+		return i.export(value.Elem(), seen)
+	case reflect.Ptr:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return i.exportOnce(value, seen, func() (interface{}, error) {
+			return i.export(value.Elem(), seen)
+		})
+	case reflect.Struct:
+		return i.exportStruct(value, seen)
+	case reflect.Array:
+		return i.exportElements(value, seen)
+	case reflect.Slice:
+		if value.IsNil() {
+			return nil, nil
+		}
+		if basicKind(value.Type().Elem().Kind()) {
+			return value.Interface(), nil
+		}
+		return i.exportOnce(value, seen, func() (interface{}, error) {
+			return i.exportElements(value, seen)
+		})
+	case reflect.Map:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return i.exportOnce(value, seen, func() (interface{}, error) {
+			return i.exportMap(value, seen)
+		})
+	}
+	return nil, fmt.Errorf("cannot export %s", value.Type())
+}
+
+// exportOnce exports the slice, map or pointer value with export, unless it
+// is already being exported.
+func (i *Evaluator) exportOnce(value reflect.Value, seen exports, export func() (interface{}, error)) (interface{}, error) {
+	key := visit{left: value.Pointer(), leftType: value.Type()}
+	if seen[key] {
+		return nil, fmt.Errorf("cannot export cyclic %s", value.Type())
+	}
+	seen[key] = true
+	defer delete(seen, key)
+	return export()
+}
+
+// exportStruct converts a struct to a map of the fields expressions can
+// read. Embedded structs are flattened, as their fields are promoted.
+func (i *Evaluator) exportStruct(value reflect.Value, seen exports) (interface{}, error) {
+	t := value.Type()
+	result := map[string]interface{}{}
+	for name, field := range i.naming.fields(t).exact {
+		if field.Anonymous && indirectType(field.Type).Kind() == reflect.Struct {
+			continue
+		}
+		if _, denied := i.deniedField(t, field); denied {
+			continue
+		}
+		v, err := value.FieldByIndexErr(field.Index)
+		if err != nil || !v.CanInterface() {
+			// The field is promoted through a nil pointer, or through an
+			// unexported struct.
+			continue
+		}
+		exported, err := i.export(v, seen)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = exported
+	}
+	return result, nil
+}
+
+func (i *Evaluator) exportElements(value reflect.Value, seen exports) (interface{}, error) {
+	result := make([]interface{}, value.Len())
+	for n := range result {
+		exported, err := i.export(value.Index(n), seen)
+		if err != nil {
+			return nil, err
+		}
+		result[n] = exported
+	}
+	return result, nil
+}
+
+func (i *Evaluator) exportMap(value reflect.Value, seen exports) (interface{}, error) {
+	result := reflect.MakeMapWithSize(reflect.MapOf(value.Type().Key(), interfaceType), value.Len())
+	iter := value.MapRange()
+	for iter.Next() {
+		exported, err := i.export(iter.Value(), seen)
+		if err != nil {
+			return nil, err
+		}
+		if exported == nil {
+			result.SetMapIndex(iter.Key(), reflect.Zero(interfaceType))
+		} else {
+			result.SetMapIndex(iter.Key(), reflect.ValueOf(exported))
+		}
+	}
+	return result.Interface(), nil
+}
+
+func basicKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+type (
+	// FieldNaming sets how expressions name the fields of structs. A field
+	// tagged `xpress:"name"` is always called name, and a field tagged
+	// `xpress:"-"` cannot be reached.
+	FieldNaming struct {
+		// JSONTags names fields without an xpress tag after their json tag,
+		// so that `user.first_name` reads a field tagged `json:"first_name"`.
+		// Fields tagged `json:"-"` cannot be reached.
+		JSONTags bool
+		// LowerFirst lowers the first letter of the names of untagged
+		// fields, so that `user.firstName` reads FirstName.
+		LowerFirst bool
+		// CaseInsensitive makes names that match no field exactly match
+		// them regardless of case.
+		CaseInsensitive bool
+	}
+
+	// structFields indexes the fields of a struct type by the name
+	// expressions use for them.
+	structFields struct {
+		exact  map[string]reflect.StructField
+		folded map[string]reflect.StructField
+	}
+
+	fieldsKey struct {
+		t      reflect.Type
+		naming FieldNaming
+	}
+)
+
+// fieldsCache holds the structFields of every struct type and FieldNaming
+// seen so far.
+var fieldsCache sync.Map
+
+// SetFieldNaming sets how expressions name the fields of structs. By
+// default fields are named as in Go, unless they have an xpress tag.
+func (i *Evaluator) SetFieldNaming(naming FieldNaming) {
+	i.naming = naming
+}
+
+// field returns the field of the struct t that expressions call name.
+func (n FieldNaming) field(t reflect.Type, name string) (reflect.StructField, bool) {
+	fields := n.fields(t)
+	if field, ok := fields.exact[name]; ok {
+		return field, true
+	}
+	if n.CaseInsensitive {
+		field, ok := fields.folded[strings.ToLower(name)]
+		return field, ok
+	}
+	return reflect.StructField{}, false
+}
+
+// fields returns the structFields of the struct t.
+func (n FieldNaming) fields(t reflect.Type) *structFields {
+	key := fieldsKey{t: t, naming: n}
+	cached, ok := fieldsCache.Load(key)
+	if !ok {
+		cached, _ = fieldsCache.LoadOrStore(key, n.index(t))
+	}
+	return cached.(*structFields)
+}
+
+// index builds the structFields of t. When several fields get the same
+// name, the least deeply embedded one wins, as in Go.
+func (n FieldNaming) index(t reflect.Type) *structFields {
+	fields := &structFields{exact: map[string]reflect.StructField{}}
+	if n.CaseInsensitive {
+		fields.folded = map[string]reflect.StructField{}
+	}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() {
+			continue
+		}
+		name, ok := n.name(field)
+		if !ok {
+			continue
+		}
+		if other, ok := fields.exact[name]; !ok || len(field.Index) < len(other.Index) {
+			fields.exact[name] = field
+		}
+		if fields.folded == nil {
+			continue
+		}
+		folded := strings.ToLower(name)
+		if other, ok := fields.folded[folded]; !ok || len(field.Index) < len(other.Index) {
+			fields.folded[folded] = field
+		}
+	}
+	return fields
+}
+
+// name returns the name expressions use for field, or false if they cannot
+// reach it.
+func (n FieldNaming) name(field reflect.StructField) (string, bool) {
+	if name, ok := tagName(field.Tag.Get("xpress")); ok {
+		return name, name != "-"
+	}
+	if n.JSONTags {
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			// As in encoding/json, `json:"-,"` names the field "-" instead.
+			return "", false
+		}
+		if name, ok := tagName(tag); ok {
+			return name, true
+		}
+	}
+	if n.LowerFirst {
+		r, size := utf8.DecodeRuneInString(field.Name)
+		return string(unicode.ToLower(r)) + field.Name[size:], true
+	}
+	return field.Name, true
+}
+
+// tagName returns the name in a struct tag value such as `name,omitempty`.
+func tagName(tag string) (string, bool) {
+	name, _, _ := strings.Cut(tag, ",")
+	return name, name != ""
+}
@@ -0,0 +1,175 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+type (
+	// Closure is the value of a lambda expression: the lambda with the
+	// variables in scope where it was evaluated. Closures can be called from
+	// expressions, and are converted to the function type of the parameter
+	// when they are passed to a Go function, such as
+	// `func(interface{}) interface{}`. Go functions that take an interface{}
+	// receive the *Closure itself.
+	//
+	// A closure evaluates its body within the evaluation that created it, so
+	// it must not be called concurrently.
+	Closure struct {
+		params []string
+		scope  *Scope
+		run    func(ctx context.Context, scope *Scope) (interface{}, error)
+	}
+
+	// closureError carries the error of a closure out of a Go function that
+	// called it through a function type that cannot return errors.
+	closureError struct {
+		err error
+	}
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func newClosure(expr *Lambda, scope *Scope, run func(context.Context, *Scope) (interface{}, error)) *Closure {
+	params := make([]string, len(expr.params))
+	for i, param := range expr.params {
+		params[i] = param.lexeme
+	}
+	return &Closure{params: params, scope: scope, run: run}
+}
+
+// Call evaluates the body of the closure with args bound to its parameters.
+// Missing arguments are nil and extra arguments are ignored.
+func (c *Closure) Call(ctx context.Context, args ...interface{}) (interface{}, error) {
+	vars := make(map[string]interface{}, len(c.params))
+	for i, param := range c.params {
+		if i < len(args) {
+			vars[param] = args[i]
+		} else {
+			vars[param] = nil
+		}
+	}
+	return c.run(ctx, NewScope(c.scope, vars))
+}
+
+// Params returns the names of the parameters of the closure.
+func (c *Closure) Params() []string {
+	return c.params
+}
+
+func (i *evaluation) VisitLambdaExpr(ctx context.Context, expr *Lambda) EvaluationResult {
+	if ctx.Err() != nil {
+		return &result{err: EvaluationCancelledErrror}
+	}
+	return &result{value: newClosure(expr, i.scope, func(ctx context.Context, scope *Scope) (interface{}, error) {
+		outer, lenient := i.scope, i.lenient
+		i.scope, i.lenient = scope, false
+		defer func() {
+			i.scope, i.lenient = outer, lenient
+		}()
+		res := i.interpret(ctx, expr.body)
+		return res.Get(), res.Error()
+	})}
+}
+
+func (c *compiler) lambda(expr *Lambda) compiled {
+	body := c.compile(expr.body)
+	return func(f *frame) (interface{}, error) {
+		return newClosure(expr, f.scope, func(_ context.Context, scope *Scope) (interface{}, error) {
+			if f.cancelled() {
+				return nil, EvaluationCancelledErrror
+			}
+			outer := f.scope
+			f.scope = scope
+			defer func() {
+				f.scope = outer
+			}()
+			return body(f)
+		}), nil
+	}
+}
+
+// argument converts the value of an argument to the type of the parameter
+// it is passed to. ok is false if it cannot be converted.
+func (e *Evaluator) argument(ctx context.Context, arg interface{}, param reflect.Type) (reflect.Value, bool) {
+	if arg == nil {
+		switch param.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+			return reflect.Zero(param), true
+		}
+		return reflect.Value{}, false
+	}
+	value := reflect.ValueOf(arg)
+	if value.Type().AssignableTo(param) {
+		return value, true
+	}
+	if closure, ok := arg.(*Closure); ok {
+		if param.Kind() != reflect.Func {
+			return reflect.Value{}, false
+		}
+		return e.bind(ctx, closure, param), true
+	}
+	if isNumber(arg) {
+		switch param.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if integer, ok := toInt64(arg); ok {
+				return reflect.ValueOf(integer).Convert(param), true
+			}
+			number, _ := toFloat64(arg)
+			return reflect.ValueOf(number).Convert(param), true
+		case reflect.Float32, reflect.Float64:
+			number, _ := toFloat64(arg)
+			return reflect.ValueOf(number).Convert(param), true
+		}
+		// Numbers are convertible to strings, as runes.
+		return reflect.Value{}, false
+	}
+	if value.Type().ConvertibleTo(param) {
+		return value.Convert(param), true
+	}
+	return reflect.Value{}, false
+}
+
+// bind turns closure into a function of type fn. A context parameter of fn
+// is used to call the closure instead of being passed to it. If fn cannot
+// return the error of the closure, it panics with a closureError that call
+// recovers.
+func (e *Evaluator) bind(ctx context.Context, closure *Closure, fn reflect.Type) reflect.Value {
+	return reflect.MakeFunc(fn, func(in []reflect.Value) []reflect.Value {
+		callCtx := ctx
+		if takesContext(fn) {
+			callCtx, in = in[0].Interface().(context.Context), in[1:]
+		}
+		args := make([]interface{}, len(in))
+		for i, arg := range in {
+			args[i] = arg.Interface()
+		}
+		value, err := closure.Call(callCtx, args...)
+
+		out := make([]reflect.Value, fn.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(fn.Out(i))
+		}
+		returnsError := fn.NumOut() > 0 && fn.Out(fn.NumOut()-1) == errorType
+		if err == nil && len(out) > 0 && !(returnsError && len(out) == 1) {
+			result, ok := e.argument(callCtx, value, fn.Out(0))
+			if ok {
+				out[0] = result
+			} else {
+				err = fmt.Errorf("lambda returned %v, which cannot be used as %s", value, fn.Out(0))
+			}
+		}
+		if err != nil {
+			if !returnsError {
+				panic(&closureError{err: err})
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+		}
+		return out
+	})
+}
@@ -3,14 +3,19 @@ package parser
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
 const (
-	leftDelim    = "@{{"
-	rightDelim   = "}}"
+	// DefaultLeftDelim is the delimiter that opens an action when none is configured.
+	DefaultLeftDelim = "@{{"
+	// DefaultRightDelim is the delimiter that closes an action when none is configured.
+	DefaultRightDelim = "}}"
+
 	eof          = -1
 	alpha        = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 	digit        = "0123456789"
@@ -24,6 +29,8 @@ var (
 		"false": FALSE,
 		"true":  TRUE,
 		"nil":   NIL,
+		"not":   NOT,
+		"in":    IN,
 	}
 
 	tokenMap = map[TokenType]string{
@@ -56,15 +63,24 @@ var (
 		TEMPLATE_LEFT_BRACE:  "TEMPLATE_LEFT_BRACE",
 		TEMPLATE_RIGHT_BRACE: "TEMPLATE_RIGHT_BRACE",
 		OPTIONALCHAIN:        "OPTIONALCHAIN",
+		NULLCOALESCING:       "NULLCOALESCING",
+		FALSY_COALESCING:     "FALSY_COALESCING",
+		STAR_STAR:            "STAR_STAR",
+		SLASH_SLASH:          "SLASH_SLASH",
+		ARROW:                "ARROW",
 		AND:                  "AND",
 		OR:                   "OR",
 		IDENTIFIER:           "IDENTIFIER",
 		STRING:               "STRING",
 		NUMBER:               "NUMBER",
+		DURATION:             "DURATION",
 		TEXT:                 "TEXT",
 		FALSE:                "FALSE",
 		TRUE:                 "TRUE",
 		NIL:                  "NIL",
+		NOT:                  "NOT",
+		IN:                   "IN",
+		NOT_IN:               "NOT_IN",
 	}
 )
 
@@ -77,27 +93,90 @@ type (
 	}
 	TokenType int
 
+	// LexerOptions configures a Lexer. Empty delimiters fall back to
+	// DefaultLeftDelim and DefaultRightDelim.
+	LexerOptions struct {
+		LeftDelim  string
+		RightDelim string
+		// Expression makes the lexer treat the whole source as the inside of
+		// an action, so no delimiters are expected.
+		Expression bool
+	}
+
 	Lexer struct {
-		source    string
-		tokens    []Token
-		start     int
-		lineStart int
-		current   int
-		line      int
-		nesting   int
+		source     string
+		tokens     []Token
+		start      int
+		lineStart  int
+		current    int
+		line       int
+		nesting    int
+		leftDelim  string
+		rightDelim string
+		expression bool
+		width      int
 	}
 	stateFn func(*Lexer) stateFn
 )
 
 func NewLexer(source string) *Lexer {
+	return NewLexerWithOptions(source, LexerOptions{})
+}
+
+// NewLexerWithOptions creates a lexer that recognises the delimiters in options.
+func NewLexerWithOptions(source string, options LexerOptions) *Lexer {
+	if options.LeftDelim == "" {
+		options.LeftDelim = DefaultLeftDelim
+	}
+	if options.RightDelim == "" {
+		options.RightDelim = DefaultRightDelim
+	}
 	return &Lexer{
-		source:    source,
-		tokens:    make([]Token, 0),
-		start:     0,
-		current:   0,
-		lineStart: 1,
-		line:      1,
+		source:     source,
+		tokens:     make([]Token, 0),
+		start:      0,
+		current:    0,
+		lineStart:  1,
+		line:       1,
+		leftDelim:  options.LeftDelim,
+		rightDelim: options.RightDelim,
+		expression: options.Expression,
+	}
+}
+
+// Lexeme returns the source text of the token. For ERROR tokens it is the
+// error message instead.
+func (t Token) Lexeme() string {
+	return t.lexeme
+}
+
+func (t Token) Type() TokenType {
+	return t.tokenType
+}
+
+// Offset returns the byte offset of the token in the source.
+func (t Token) Offset() int {
+	return t.start
+}
+
+func (t Token) Line() int {
+	return t.line
+}
+
+func (t TokenType) String() string {
+	if name, ok := tokenMap[t]; ok {
+		return name
 	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
+// end returns the byte offset just past the token in the source.
+func (t Token) end() int {
+	switch t.tokenType {
+	case EOF, ERROR:
+		return t.start
+	}
+	return t.start + len(t.lexeme)
 }
 
 func (l *Lexer) scanTokens() []Token {
@@ -105,7 +184,11 @@ func (l *Lexer) scanTokens() []Token {
 	return l.tokens
 }
 func (l *Lexer) run() {
-	for state := lexText; state != nil; {
+	state := lexText
+	if l.expression {
+		state = lexInsideAction
+	}
+	for state != nil {
 		state = state(l)
 	}
 	l.tokens = append(l.tokens, Token{"", EOF, l.current, l.lineStart})
@@ -134,10 +217,12 @@ func (l *Lexer) ignore() {
 
 func (l *Lexer) next() rune {
 	if int(l.current) >= len(l.source) {
+		l.width = 0
 		return eof
 	}
 	r, w := utf8.DecodeRuneInString(l.source[l.current:])
 	l.current += w
+	l.width = w
 	if r == '\n' {
 		l.line++
 	}
@@ -151,11 +236,12 @@ func (l *Lexer) peek() rune {
 	return r
 }
 
-// backup steps back one rune.
+// backup steps back one rune. Can only be called once per call of next.
 func (l *Lexer) backup() {
-	if !l.isAtEnd() && l.current > 0 {
+	if l.width > 0 {
 		r, w := utf8.DecodeLastRuneInString(l.source[:l.current])
-		l.current -= (w)
+		l.current -= w
+		l.width = 0
 		// Correct newline count.
 		if r == '\n' {
 			l.line--
@@ -211,11 +297,11 @@ func (l *Lexer) atTerminator() bool {
 		'}':
 		return true
 	}
-	return strings.HasPrefix(l.source[l.current:], rightDelim)
+	return !l.expression && strings.HasPrefix(l.source[l.current:], l.rightDelim)
 }
 
 func lexText(l *Lexer) stateFn {
-	if x := strings.Index(l.source[l.start:], leftDelim); x >= 0 {
+	if x := strings.Index(l.source[l.start:], l.leftDelim); x >= 0 {
 		if x > 0 {
 			l.current += x
 			l.line += strings.Count(l.source[l.start:l.current], "\n")
@@ -234,14 +320,14 @@ func lexText(l *Lexer) stateFn {
 }
 
 func lexLeftDelim(l *Lexer) stateFn {
-	l.current += len(leftDelim)
+	l.current += len(l.leftDelim)
 	l.addToken(TEMPLATE_LEFT_BRACE)
 	l.nesting++
 	return lexInsideAction
 }
 
 func lexRightDelim(l *Lexer) stateFn {
-	l.current += len(rightDelim)
+	l.current += len(l.rightDelim)
 	l.addToken(TEMPLATE_RIGHT_BRACE)
 	l.nesting--
 	return lexText
@@ -249,10 +335,13 @@ func lexRightDelim(l *Lexer) stateFn {
 
 func lexInsideAction(l *Lexer) stateFn {
 	for {
-		if strings.HasPrefix(l.source[l.current:], rightDelim) && l.nesting == 1 {
+		if !l.expression && strings.HasPrefix(l.source[l.current:], l.rightDelim) && l.nesting == 1 {
 			return lexRightDelim
 		}
 		if l.isAtEnd() {
+			if l.expression {
+				return nil
+			}
 			return l.errorf("unclosed action")
 		}
 		switch c := l.next(); c {
@@ -276,9 +365,17 @@ func lexInsideAction(l *Lexer) stateFn {
 		case '+':
 			l.addToken(PLUS)
 		case '*':
-			l.addToken(STAR)
+			if l.accept("*") {
+				l.addToken(STAR_STAR)
+			} else {
+				l.addToken(STAR)
+			}
 		case '/':
-			l.addToken(SLASH)
+			if l.accept("/") {
+				l.addToken(SLASH_SLASH)
+			} else {
+				l.addToken(SLASH)
+			}
 		case '%':
 			l.addToken(PERCENT)
 		case '?':
@@ -286,6 +383,8 @@ func lexInsideAction(l *Lexer) stateFn {
 				l.addToken(OPTIONALCHAIN)
 			} else if l.accept("?") {
 				l.addToken(NULLCOALESCING)
+			} else if l.accept(":") {
+				l.addToken(FALSY_COALESCING)
 			} else {
 				l.addToken(QMARK)
 			}
@@ -322,6 +421,8 @@ func lexInsideAction(l *Lexer) stateFn {
 		case '=':
 			if l.accept("=") {
 				l.addToken(EQUAL_EQUAL)
+			} else if l.accept(">") {
+				l.addToken(ARROW)
 			} else {
 				l.addToken(EQUAL)
 			}
@@ -368,8 +469,8 @@ func lexIdent(l *Lexer) stateFn {
 	}
 	word := l.source[l.start:l.current]
 	if len(word) > 0 {
-		if keywords[word] > _keywordStart {
-			l.addToken(keywords[word])
+		if tokenType, ok := keywords[word]; ok {
+			l.addToken(tokenType)
 		} else {
 			l.addToken(IDENTIFIER)
 		}
@@ -412,12 +513,114 @@ func (l *Lexer) scanRawString(delim rune) error {
 	}
 }
 
+// unquote removes the quotes around a STRING lexeme and decodes its escape
+// sequences. \xHH and \uXXXX denote unicode code points, as they do in
+// javascript, and a pair of \uXXXX surrogates is combined into one rune.
+func unquote(lexeme string) (string, error) {
+	str := lexeme[1 : len(lexeme)-1]
+	if !strings.ContainsRune(str, '\\') {
+		return str, nil
+	}
+	var builder strings.Builder
+	builder.Grow(len(str))
+	for i := 0; i < len(str); {
+		if str[i] != '\\' {
+			r, w := utf8.DecodeRuneInString(str[i:])
+			builder.WriteRune(r)
+			i += w
+			continue
+		}
+		r, w, err := unescape(str[i:])
+		if err != nil {
+			return "", err
+		}
+		i += w
+		if utf16.IsSurrogate(r) {
+			if low, lw, err := unescape(str[i:]); err == nil && strings.HasPrefix(str[i:], "\\u") {
+				if decoded := utf16.DecodeRune(r, low); decoded != utf8.RuneError {
+					r = decoded
+					i += lw
+				}
+			}
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String(), nil
+}
+
+// unescape decodes the escape sequence at the start of str and returns the
+// rune it denotes together with the number of bytes consumed.
+func unescape(str string) (rune, int, error) {
+	if len(str) < 2 || str[0] != '\\' {
+		return 0, 0, errors.New("invalid escape sequence")
+	}
+	switch c := str[1]; c {
+	case 'n':
+		return '\n', 2, nil
+	case 't':
+		return '\t', 2, nil
+	case 'r':
+		return '\r', 2, nil
+	case 'b':
+		return '\b', 2, nil
+	case 'f':
+		return '\f', 2, nil
+	case 'v':
+		return '\v', 2, nil
+	case '0':
+		return 0, 2, nil
+	case '\\', '"', '\'', '/':
+		return rune(c), 2, nil
+	case 'x':
+		return unescapeHex(str, 2, 2)
+	case 'u':
+		if len(str) > 2 && str[2] == '{' {
+			end := strings.IndexByte(str, '}')
+			if end < 0 || end == 3 || end > 9 {
+				return 0, 0, fmt.Errorf("invalid escape sequence %q", prefix(str, 9))
+			}
+			r, _, err := unescapeHex(str[:end], 3, end-3)
+			if err != nil || r > unicode.MaxRune {
+				return 0, 0, fmt.Errorf("invalid escape sequence %q", str[:end+1])
+			}
+			return r, end + 1, nil
+		}
+		return unescapeHex(str, 2, 4)
+	default:
+		r, _ := utf8.DecodeRuneInString(str[1:])
+		return 0, 0, fmt.Errorf("invalid escape sequence %q", "\\"+string(r))
+	}
+}
+
+// unescapeHex decodes the size hex digits found at offset in str.
+func unescapeHex(str string, offset, size int) (rune, int, error) {
+	if len(str) < offset+size {
+		return 0, 0, fmt.Errorf("invalid escape sequence %q", str)
+	}
+	value, err := strconv.ParseUint(str[offset:offset+size], 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid escape sequence %q", str[:offset+size])
+	}
+	return rune(value), offset + size, nil
+}
+
+func prefix(str string, size int) string {
+	if len(str) < size {
+		return str
+	}
+	return str[:size]
+}
+
 // lexNumber scans a number: decimal, octal, hex and float. This
 // isn't a perfect number scanner - for instance it accepts "." and "0x0.2"
 // and "089" - but when it's wrong the input is invalid and the parser (via
 // strconv) will notice.
 func lexNumber(l *Lexer) stateFn {
 	if !l.scanNumber() {
+		if l.scanDuration() {
+			l.addToken(DURATION)
+			return lexInsideAction
+		}
 		return l.errorf("bad number syntax: %q", l.source[l.start:l.current])
 	}
 
@@ -453,6 +656,38 @@ func (l *Lexer) scanNumber() bool {
 	return true
 }
 
+// scanDuration scans a duration literal such as "90s" or "2h30m", once
+// scanNumber has stopped at the letter after its first number. Units are
+// ns, us, µs, ms, s, m, h and d.
+func (l *Lexer) scanDuration() bool {
+	l.current -= l.width
+	number := l.source[l.start:l.current]
+	if len(number) > 1 && number[0] == '0' && strings.ContainsRune("xXoObB", rune(number[1])) {
+		l.current += l.width
+		return false
+	}
+	for {
+		unit, ok := unitPrefix(l.source[l.current:])
+		if !ok {
+			l.next()
+			return false
+		}
+		l.current += len(unit.name)
+		if !l.accept("0123456789") {
+			break
+		}
+		l.acceptRun("0123456789_")
+		if l.accept(".") {
+			l.acceptRun("0123456789_")
+		}
+	}
+	if isAlphaNumeric(l.peek()) {
+		l.next()
+		return false
+	}
+	return true
+}
+
 // isSpace reports whether r is a space character.
 func isSpace(r rune) bool {
 	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
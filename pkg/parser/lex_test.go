@@ -189,3 +189,132 @@ func TestTernaryWithFunctionCalls(t *testing.T) {
 		lex.tokens,
 	)
 }
+
+func TestLexerCustomDelimiters(t *testing.T) {
+	lex := NewLexerWithOptions(`a ${ {b: 1} } c`, LexerOptions{LeftDelim: "${", RightDelim: "}"})
+	lex.run()
+	assert.Equal(
+		t,
+		[]Token{
+			{lexeme: "a ", tokenType: TEXT, start: 0, line: 1},
+			{lexeme: "${", tokenType: TEMPLATE_LEFT_BRACE, start: 2, line: 1},
+			{lexeme: "{", tokenType: LEFT_BRACE, start: 5, line: 1},
+			{lexeme: "b", tokenType: IDENTIFIER, start: 6, line: 1},
+			{lexeme: ":", tokenType: COLON, start: 7, line: 1},
+			{lexeme: "1", tokenType: NUMBER, start: 9, line: 1},
+			{lexeme: "}", tokenType: RIGHT_BRACE, start: 10, line: 1},
+			{lexeme: "}", tokenType: TEMPLATE_RIGHT_BRACE, start: 12, line: 1},
+			{lexeme: " c", tokenType: TEXT, start: 13, line: 1},
+			{lexeme: "", tokenType: EOF, start: 15, line: 1},
+		},
+		lex.tokens,
+	)
+}
+
+func TestArithmeticOperators(t *testing.T) {
+	lex := NewLexer(`@{{1%2**3//4}}`)
+	lex.run()
+	assert.Equal(
+		t,
+		[]Token{
+			{lexeme: "@{{", tokenType: TEMPLATE_LEFT_BRACE, start: 0, line: 1},
+			{lexeme: "1", tokenType: NUMBER, start: 3, line: 1},
+			{lexeme: "%", tokenType: PERCENT, start: 4, line: 1},
+			{lexeme: "2", tokenType: NUMBER, start: 5, line: 1},
+			{lexeme: "**", tokenType: STAR_STAR, start: 6, line: 1},
+			{lexeme: "3", tokenType: NUMBER, start: 8, line: 1},
+			{lexeme: "//", tokenType: SLASH_SLASH, start: 9, line: 1},
+			{lexeme: "4", tokenType: NUMBER, start: 11, line: 1},
+			{lexeme: "}}", tokenType: TEMPLATE_RIGHT_BRACE, start: 12, line: 1},
+			{lexeme: "", tokenType: EOF, start: 14, line: 1},
+		},
+		lex.tokens,
+	)
+}
+
+func TestArrow(t *testing.T) {
+	lex := NewLexer(`@{{(a)=>a==b}}`)
+	lex.run()
+	assert.Equal(
+		t,
+		[]Token{
+			{lexeme: "@{{", tokenType: TEMPLATE_LEFT_BRACE, start: 0, line: 1},
+			{lexeme: "(", tokenType: LEFT_PAREN, start: 3, line: 1},
+			{lexeme: "a", tokenType: IDENTIFIER, start: 4, line: 1},
+			{lexeme: ")", tokenType: RIGHT_PAREN, start: 5, line: 1},
+			{lexeme: "=>", tokenType: ARROW, start: 6, line: 1},
+			{lexeme: "a", tokenType: IDENTIFIER, start: 8, line: 1},
+			{lexeme: "==", tokenType: EQUAL_EQUAL, start: 9, line: 1},
+			{lexeme: "b", tokenType: IDENTIFIER, start: 11, line: 1},
+			{lexeme: "}}", tokenType: TEMPLATE_RIGHT_BRACE, start: 12, line: 1},
+			{lexeme: "", tokenType: EOF, start: 14, line: 1},
+		},
+		lex.tokens,
+	)
+}
+
+func TestDurations(t *testing.T) {
+	lex := NewLexer(`@{{2h30m+90s-1.5d*500µs}}`)
+	lex.run()
+	assert.Equal(
+		t,
+		[]Token{
+			{lexeme: "@{{", tokenType: TEMPLATE_LEFT_BRACE, start: 0, line: 1},
+			{lexeme: "2h30m", tokenType: DURATION, start: 3, line: 1},
+			{lexeme: "+", tokenType: PLUS, start: 8, line: 1},
+			{lexeme: "90s", tokenType: DURATION, start: 9, line: 1},
+			{lexeme: "-", tokenType: MINUS, start: 12, line: 1},
+			{lexeme: "1.5d", tokenType: DURATION, start: 13, line: 1},
+			{lexeme: "*", tokenType: STAR, start: 17, line: 1},
+			{lexeme: "500µs", tokenType: DURATION, start: 18, line: 1},
+			{lexeme: "}}", tokenType: TEMPLATE_RIGHT_BRACE, start: 24, line: 1},
+			{lexeme: "", tokenType: EOF, start: 26, line: 1},
+		},
+		lex.tokens,
+	)
+
+	for _, source := range []string{"@{{5min}}", "@{{1h30}}", "@{{0x1h}}", "@{{2y}}"} {
+		lex := NewLexer(source)
+		lex.run()
+		assert.Equal(t, ERROR, lex.tokens[len(lex.tokens)-2].tokenType, source)
+	}
+}
+
+func TestKeywordOperators(t *testing.T) {
+	lex := NewLexer(`@{{a and not b or c not in d}}`)
+	lex.run()
+	assert.Equal(
+		t,
+		[]Token{
+			{lexeme: "@{{", tokenType: TEMPLATE_LEFT_BRACE, start: 0, line: 1},
+			{lexeme: "a", tokenType: IDENTIFIER, start: 3, line: 1},
+			{lexeme: "and", tokenType: AND, start: 5, line: 1},
+			{lexeme: "not", tokenType: NOT, start: 9, line: 1},
+			{lexeme: "b", tokenType: IDENTIFIER, start: 13, line: 1},
+			{lexeme: "or", tokenType: OR, start: 15, line: 1},
+			{lexeme: "c", tokenType: IDENTIFIER, start: 18, line: 1},
+			{lexeme: "not", tokenType: NOT, start: 20, line: 1},
+			{lexeme: "in", tokenType: IN, start: 24, line: 1},
+			{lexeme: "d", tokenType: IDENTIFIER, start: 27, line: 1},
+			{lexeme: "}}", tokenType: TEMPLATE_RIGHT_BRACE, start: 28, line: 1},
+			{lexeme: "", tokenType: EOF, start: 30, line: 1},
+		},
+		lex.tokens,
+	)
+}
+
+func TestLexerExpressionEndingInPunctuation(t *testing.T) {
+	lex := NewLexerWithOptions(`f(a)`, LexerOptions{Expression: true})
+	lex.run()
+	assert.Equal(
+		t,
+		[]Token{
+			{lexeme: "f", tokenType: IDENTIFIER, start: 0, line: 1},
+			{lexeme: "(", tokenType: LEFT_PAREN, start: 1, line: 1},
+			{lexeme: "a", tokenType: IDENTIFIER, start: 2, line: 1},
+			{lexeme: ")", tokenType: RIGHT_PAREN, start: 3, line: 1},
+			{lexeme: "", tokenType: EOF, start: 4, line: 1},
+		},
+		lex.tokens,
+	)
+}
@@ -0,0 +1,138 @@
+package parser
+
+import "fmt"
+
+type (
+	// Limits bounds the resources an evaluation can use, so that templates
+	// written by untrusted users can be evaluated safely. A zero field means
+	// no limit.
+	Limits struct {
+		// MaxSteps is the number of expressions an evaluation can evaluate.
+		MaxSteps int
+		// MaxDepth is how deeply expressions can be nested while evaluating.
+		// Use ParserOptions.MaxDepth to limit nesting while parsing.
+		MaxDepth int
+		// MaxStringLength is the length in bytes of the longest string `+`,
+		// string methods and functions can produce.
+		MaxStringLength int
+		// MaxCollectionSize is the number of elements of the largest array
+		// or map literal.
+		MaxCollectionSize int
+		// MaxOutputSize is the length in bytes of the longest output a
+		// template can render.
+		MaxOutputSize int
+	}
+
+	// StepLimitError is returned when an evaluation evaluates more than
+	// Limits.MaxSteps expressions.
+	StepLimitError struct {
+		Limit int
+	}
+
+	// DepthLimitError is returned when expressions are nested more deeply
+	// than Limits.MaxDepth while evaluating, or than ParserOptions.MaxDepth
+	// while parsing.
+	DepthLimitError struct {
+		Limit int
+	}
+
+	// StringLimitError is returned when `+`, a string method or a function
+	// produces a string longer than Limits.MaxStringLength.
+	StringLimitError struct {
+		Limit  int
+		Length int
+	}
+
+	// CollectionLimitError is returned when an array or map literal has
+	// more elements than Limits.MaxCollectionSize.
+	CollectionLimitError struct {
+		Limit int
+		Size  int
+	}
+
+	// OutputLimitError is returned when a template renders an output longer
+	// than Limits.MaxOutputSize.
+	OutputLimitError struct {
+		Limit int
+		Size  int
+	}
+
+	// usage counts the resources used by an evaluation.
+	usage struct {
+		steps int
+		depth int
+	}
+)
+
+// SetLimits sets the resource limits of the evaluations. Programs enforce
+// MaxSteps and MaxDepth only if they were set when the program was compiled.
+func (i *Evaluator) SetLimits(limits Limits) {
+	i.limits = limits
+}
+
+// Limits returns the resource limits of the evaluations. Strings returned
+// by functions are checked against MaxStringLength once they return, so
+// functions that can build long strings read the limits, through
+// EvaluatorFrom, to refuse to build them in the first place.
+func (i *Evaluator) Limits() Limits {
+	return i.limits
+}
+
+func (e *StepLimitError) Error() string {
+	return fmt.Sprintf("evaluation exceeded the limit of %d steps", e.Limit)
+}
+
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("expression exceeded the maximum nesting depth of %d", e.Limit)
+}
+
+func (e *StringLimitError) Error() string {
+	return fmt.Sprintf("string of length %d exceeds the limit of %d", e.Length, e.Limit)
+}
+
+func (e *CollectionLimitError) Error() string {
+	return fmt.Sprintf("collection of size %d exceeds the limit of %d", e.Size, e.Limit)
+}
+
+func (e *OutputLimitError) Error() string {
+	return fmt.Sprintf("output of size %d exceeds the limit of %d", e.Size, e.Limit)
+}
+
+// enter counts a step into an expression nested one level deeper than the
+// current one. The caller must call leave once the expression is evaluated.
+func (u *usage) enter(limits Limits) error {
+	u.steps++
+	u.depth++
+	if limits.MaxSteps > 0 && u.steps > limits.MaxSteps {
+		return &StepLimitError{Limit: limits.MaxSteps}
+	}
+	if limits.MaxDepth > 0 && u.depth > limits.MaxDepth {
+		return &DepthLimitError{Limit: limits.MaxDepth}
+	}
+	return nil
+}
+
+func (u *usage) leave() {
+	u.depth--
+}
+
+func (l Limits) checkString(str string) error {
+	if l.MaxStringLength > 0 && len(str) > l.MaxStringLength {
+		return &StringLimitError{Limit: l.MaxStringLength, Length: len(str)}
+	}
+	return nil
+}
+
+func (l Limits) checkCollection(size int) error {
+	if l.MaxCollectionSize > 0 && size > l.MaxCollectionSize {
+		return &CollectionLimitError{Limit: l.MaxCollectionSize, Size: size}
+	}
+	return nil
+}
+
+func (l Limits) checkOutput(output interface{}) error {
+	if str, ok := output.(string); ok && l.MaxOutputSize > 0 && len(str) > l.MaxOutputSize {
+		return &OutputLimitError{Limit: l.MaxOutputSize, Size: len(str)}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Module is a named set of functions that Use installs as a single
+	// member, so that its functions are called as `name.function(...)`.
+	Module struct {
+		Name      string
+		Doc       string
+		Functions []Function
+	}
+
+	// Function is a function of a module, with the signature and
+	// documentation that tooling such as editors can show.
+	Function struct {
+		Name   string
+		Doc    string
+		Params []Param
+		// Result is the type of the value the function returns.
+		Result string
+		// Func is the Go function called from expressions. It is called like
+		// any other member function.
+		Func interface{}
+	}
+
+	// Param is a parameter of a Function. Types are the names used in the
+	// documentation of expressions: number, string, bool, array, map,
+	// function, time, duration or any.
+	Param struct {
+		Name     string
+		Type     string
+		Optional bool
+		// Variadic is true for a last parameter that takes any number of
+		// arguments.
+		Variadic bool
+	}
+)
+
+// evaluatorKey is the key of the Evaluator in the context of the functions
+// it calls.
+type evaluatorKey struct{}
+
+// EvaluatorFrom returns the Evaluator that called a function from the
+// context the function takes as its first parameter, so that modules can
+// follow its clock, limits and access policy.
+func EvaluatorFrom(ctx context.Context) (*Evaluator, bool) {
+	e, ok := ctx.Value(evaluatorKey{}).(*Evaluator)
+	return e, ok
+}
+
+// Use installs modules as members named after them, replacing members of
+// the same name.
+func (i *Evaluator) Use(modules ...Module) error {
+	for _, module := range modules {
+		if module.Name == "" {
+			return fmt.Errorf("module has no name")
+		}
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.members == nil {
+		i.members = make(map[string]interface{}, len(modules))
+	}
+	for _, module := range modules {
+		functions := make(map[string]interface{}, len(module.Functions))
+		for _, fn := range module.Functions {
+			functions[fn.Name] = fn.Func
+		}
+		i.members[module.Name] = functions
+	}
+	return nil
+}
+
+// Signature returns the signature of f, such as
+// `round(x number, places? number) number`.
+func (f Function) Signature() string {
+	params := make([]string, len(f.Params))
+	for i, param := range f.Params {
+		name := param.Name
+		if param.Variadic {
+			name = "..." + name
+		} else if param.Optional {
+			name += "?"
+		}
+		params[i] = name + " " + param.Type
+	}
+	signature := f.Name + "(" + strings.Join(params, ", ") + ")"
+	if f.Result != "" {
+		signature += " " + f.Result
+	}
+	return signature
+}
@@ -3,84 +3,155 @@ package parser
 import (
 	"context"
 	"fmt"
+	"strings"
 )
 
 type (
 	Binary struct {
+		node
 		left     Expr
 		operator Token
 		right    Expr
 	}
 
 	Grouping struct {
+		node
 		expression Expr
 	}
 
 	Literal struct {
+		node
 		value interface{}
 		raw   string
 	}
 
 	Unary struct {
+		node
 		operator Token
 		right    Expr
 	}
 
 	Template struct {
+		node
 		expressions []Expr
 	}
 
 	Parser struct {
 		tokens  []Token
 		current int
+		options ParserOptions
+		source  string
+		lines   []int
+
+		recovering bool
+		errors     ErrorList
+		depth      int
+	}
+
+	// ParserOptions configures a Parser. Empty delimiters fall back to
+	// DefaultLeftDelim and DefaultRightDelim.
+	ParserOptions struct {
+		LeftDelim  string
+		RightDelim string
+		// Expression parses the source as a single bare expression, such as
+		// `user.age > 18`, instead of a template.
+		Expression bool
+		// ChainedComparisons parses `a < b < c` as `a < b && b < c`, with b
+		// evaluated only once, instead of `(a < b) < c`.
+		ChainedComparisons bool
+		// MaxDepth limits how deeply expressions can be nested. Deeper
+		// expressions are a ParseError that wraps a DepthLimitError. Zero
+		// means no limit.
+		MaxDepth int
 	}
 
 	Ternary struct {
+		node
 		condition Expr
 		trueExpr  Expr
 		falseExpr Expr
 	}
 
 	Get struct {
+		node
 		object Expr
 		name   Token
 	}
 
 	Optional struct {
+		node
 		left Expr
 	}
 
 	Call struct {
+		node
 		callee    Expr
 		arguments []Expr
 	}
 
 	Index struct {
+		node
 		object Expr
 		index  Expr
 	}
 
 	Array struct {
+		node
 		values []Expr
 	}
 
 	Variable struct {
+		node
 		name Token
 	}
 
+	// ParseError describes a syntax error. It is also an Expr so that a
+	// failed parse can still be handed to the evaluator, which reports it.
 	ParseError struct {
-		token   Token
-		message string
+		node
+		// Message describes the error.
+		Message string
+		// Token is the token at which the error was detected.
+		Token Token
+		// Expected describes what the parser expected to find instead of
+		// Token, e.g. "expression" or "')'". It is empty when the error is
+		// not about a missing token.
+		Expected string
+		// Offset, Line and Column locate Token in the source.
+		Offset int
+		Line   int
+		Column int
+		// Err is the error that caused the syntax error, if any.
+		Err error
 	}
 
+	// ErrorList is the list of syntax errors found by Parser.ParseAll, in
+	// the order they appear in the source.
+	ErrorList []*ParseError
+
 	Map struct {
+		node
 		entries []*MapEntry
 	}
 
 	MapEntry struct {
+		node
 		key   Expr
 		value Expr
 	}
+
+	Comparison struct {
+		node
+		operands  []Expr
+		operators []Token
+	}
+
+	// Lambda is a function literal such as `x => x * 2` or `(a, b) => a + b`.
+	Lambda struct {
+		node
+		params []Token
+		body   Expr
+	}
 )
 
 func NewBinary(left Expr, operator Token, right Expr) *Binary {
@@ -88,7 +159,19 @@ func NewBinary(left Expr, operator Token, right Expr) *Binary {
 }
 
 func (b *Binary) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitBinaryExpr(ctx, b)
+	return v.VisitBinaryExpr(ctx, b)
+}
+
+func (b *Binary) Left() Expr {
+	return b.left
+}
+
+func (b *Binary) Operator() Token {
+	return b.operator
+}
+
+func (b *Binary) Right() Expr {
+	return b.right
 }
 
 func NewGrouping(expression Expr) *Grouping {
@@ -96,7 +179,11 @@ func NewGrouping(expression Expr) *Grouping {
 }
 
 func (g *Grouping) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitGroupingExpr(ctx, g)
+	return v.VisitGroupingExpr(ctx, g)
+}
+
+func (g *Grouping) Expression() Expr {
+	return g.expression
 }
 
 func NewLiteral(value interface{}, raw string) *Literal {
@@ -104,7 +191,16 @@ func NewLiteral(value interface{}, raw string) *Literal {
 }
 
 func (l *Literal) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitLiteralExpr(ctx, l)
+	return v.VisitLiteralExpr(ctx, l)
+}
+
+func (l *Literal) Value() interface{} {
+	return l.value
+}
+
+// Raw returns the source text of the literal.
+func (l *Literal) Raw() string {
+	return l.raw
 }
 
 func NewUnary(operator Token, right Expr) *Unary {
@@ -112,7 +208,15 @@ func NewUnary(operator Token, right Expr) *Unary {
 }
 
 func (u *Unary) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitUnaryExpr(ctx, u)
+	return v.VisitUnaryExpr(ctx, u)
+}
+
+func (u *Unary) Operator() Token {
+	return u.operator
+}
+
+func (u *Unary) Right() Expr {
+	return u.right
 }
 
 func NewTemplate(expressions []Expr) *Template {
@@ -120,7 +224,11 @@ func NewTemplate(expressions []Expr) *Template {
 }
 
 func (t *Template) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitTemplateExpr(ctx, t)
+	return v.VisitTemplateExpr(ctx, t)
+}
+
+func (t *Template) Expressions() []Expr {
+	return t.expressions
 }
 
 func NewTernary(condition Expr, trueExpr Expr, falseExpr Expr) *Ternary {
@@ -128,7 +236,21 @@ func NewTernary(condition Expr, trueExpr Expr, falseExpr Expr) *Ternary {
 }
 
 func (t *Ternary) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitTernaryExpr(ctx, t)
+	return v.VisitTernaryExpr(ctx, t)
+}
+
+func (t *Ternary) Condition() Expr {
+	return t.condition
+}
+
+// TrueExpr returns the expression evaluated when the condition is truthy.
+func (t *Ternary) TrueExpr() Expr {
+	return t.trueExpr
+}
+
+// FalseExpr returns the expression evaluated when the condition is falsy.
+func (t *Ternary) FalseExpr() Expr {
+	return t.falseExpr
 }
 
 func NewGet(object Expr, name Token) *Get {
@@ -136,7 +258,16 @@ func NewGet(object Expr, name Token) *Get {
 }
 
 func (g *Get) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitGetExpr(ctx, g)
+	return v.VisitGetExpr(ctx, g)
+}
+
+func (g *Get) Object() Expr {
+	return g.object
+}
+
+// Name returns the identifier of the property being read.
+func (g *Get) Name() Token {
+	return g.name
 }
 
 func NewOptional(left Expr) *Optional {
@@ -144,7 +275,12 @@ func NewOptional(left Expr) *Optional {
 }
 
 func (o *Optional) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitOptionalExpr(ctx, o)
+	return v.VisitOptionalExpr(ctx, o)
+}
+
+// Left returns the expression whose nil value short-circuits the rest of the chain.
+func (o *Optional) Left() Expr {
+	return o.left
 }
 
 func NewCall(callee Expr, arguments []Expr) *Call {
@@ -152,7 +288,15 @@ func NewCall(callee Expr, arguments []Expr) *Call {
 }
 
 func (c *Call) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitCallExpr(ctx, c)
+	return v.VisitCallExpr(ctx, c)
+}
+
+func (c *Call) Callee() Expr {
+	return c.callee
+}
+
+func (c *Call) Arguments() []Expr {
+	return c.arguments
 }
 
 func NewIndex(object Expr, index Expr) *Index {
@@ -160,7 +304,15 @@ func NewIndex(object Expr, index Expr) *Index {
 }
 
 func (i *Index) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitIndexExpr(ctx, i)
+	return v.VisitIndexExpr(ctx, i)
+}
+
+func (i *Index) Object() Expr {
+	return i.object
+}
+
+func (i *Index) Index() Expr {
+	return i.index
 }
 
 func NewArray(values []Expr) *Array {
@@ -168,7 +320,11 @@ func NewArray(values []Expr) *Array {
 }
 
 func (a *Array) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitArrayExpr(ctx, a)
+	return v.VisitArrayExpr(ctx, a)
+}
+
+func (a *Array) Values() []Expr {
+	return a.values
 }
 
 func NewVariable(name Token) *Variable {
@@ -176,15 +332,57 @@ func NewVariable(name Token) *Variable {
 }
 
 func (v *Variable) Accept(ctx context.Context, vis Visitor) EvaluationResult {
-	return vis.visitVariableExpr(ctx, v)
+	return vis.VisitVariableExpr(ctx, v)
+}
+
+func (v *Variable) Name() Token {
+	return v.name
 }
 
 func (pe *ParseError) Error() string {
-	return fmt.Sprintf("Error at position %d. %s", pe.token.start, pe.message)
+	return fmt.Sprintf("Error at line %d, column %d. %s", pe.Line, pe.Column, pe.Message)
+}
+
+// Unwrap returns the error that caused the parse error, such as a
+// *DepthLimitError, or nil.
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+// Format renders the error with the offending line of source underlined.
+func (pe *ParseError) Format(source string) string {
+	return formatSnippet(source, pe.Span(), pe.Message)
+}
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns l as an error, or nil if l is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Format renders every error in the list with its line of source underlined.
+func (l ErrorList) Format(source string) string {
+	builder := strings.Builder{}
+	for _, err := range l {
+		builder.WriteString(err.Format(source))
+	}
+	return builder.String()
 }
 
 func (p *ParseError) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitParseErrorExpr(ctx, p)
+	return v.VisitParseErrorExpr(ctx, p)
 }
 
 func NewMap(entries []*MapEntry) *Map {
@@ -192,7 +390,11 @@ func NewMap(entries []*MapEntry) *Map {
 }
 
 func (m *Map) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitMapExpr(ctx, m)
+	return v.VisitMapExpr(ctx, m)
+}
+
+func (m *Map) Entries() []*MapEntry {
+	return m.entries
 }
 
 func NewMapEntry(key Expr, value Expr) *MapEntry {
@@ -200,5 +402,46 @@ func NewMapEntry(key Expr, value Expr) *MapEntry {
 }
 
 func (me *MapEntry) Accept(ctx context.Context, v Visitor) EvaluationResult {
-	return v.visitMapEntryExpr(ctx, me)
+	return v.VisitMapEntryExpr(ctx, me)
+}
+
+func (me *MapEntry) Key() Expr {
+	return me.key
+}
+
+func (me *MapEntry) Value() Expr {
+	return me.value
+}
+
+func NewComparison(operands []Expr, operators []Token) *Comparison {
+	return &Comparison{operands: operands, operators: operators}
+}
+
+func (c *Comparison) Accept(ctx context.Context, v Visitor) EvaluationResult {
+	return v.VisitComparisonExpr(ctx, c)
+}
+
+// Operands returns the compared expressions. There is one more operand than operators.
+func (c *Comparison) Operands() []Expr {
+	return c.operands
+}
+
+func (c *Comparison) Operators() []Token {
+	return c.operators
+}
+
+func NewLambda(params []Token, body Expr) *Lambda {
+	return &Lambda{params: params, body: body}
+}
+
+func (l *Lambda) Accept(ctx context.Context, v Visitor) EvaluationResult {
+	return v.VisitLambdaExpr(ctx, l)
+}
+
+func (l *Lambda) Params() []Token {
+	return l.params
+}
+
+func (l *Lambda) Body() Expr {
+	return l.body
 }
@@ -0,0 +1,510 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NumberMode sets how an Evaluator represents numbers.
+type NumberMode int
+
+const (
+	// FloatNumbers makes every number a float64, as in javascript. Integers
+	// above 2^53 lose precision. It is the default.
+	FloatNumbers NumberMode = iota
+	// IntegerNumbers keeps integers exact. Integer literals are int64, and
+	// arithmetic on two integers gives an int64, or an error if it
+	// overflows. `/` always gives a float64.
+	IntegerNumbers
+	// DecimalNumbers is like IntegerNumbers, but literals with a fractional
+	// part are exact decimals held in a *big.Rat, and `/` gives a *big.Rat.
+	DecimalNumbers
+)
+
+// decimalDigits is the number of digits after the point of decimals that
+// have no finite decimal expansion, such as 1/3, when they are formatted.
+const decimalDigits = 34
+
+// maxExactExponent is the largest exponent `**` raises a decimal to
+// exactly. Larger exponents give a float64.
+const maxExactExponent = 1024
+
+// SetNumberMode sets how numbers are represented. In IntegerNumbers and
+// DecimalNumbers modes, the operands of arithmetic and comparison
+// operators are promoted to a common type first:
+//
+//   - two integers of any Go integer type stay integers, as int64;
+//   - a *big.Rat and an integer or a float64 become *big.Rat, floats
+//     being converted through their shortest decimal representation;
+//   - anything else becomes float64.
+//
+// In FloatNumbers mode, every operand becomes float64. Programs keep the
+// literals of the mode they were compiled in.
+func (i *Evaluator) SetNumberMode(mode NumberMode) {
+	i.numbers = mode
+}
+
+// literal returns the value of expr in the number mode of the evaluator.
+// Integer literals that do not fit in an int64 stay float64.
+func (e *Evaluator) literal(expr *Literal) interface{} {
+	if _, ok := expr.value.(float64); !ok || e.numbers == FloatNumbers || expr.raw == "" {
+		return expr.value
+	}
+	raw := expr.raw
+	base := 10
+	if hasBasePrefix(raw) {
+		// ParseInt reads the prefix itself, but would also read a leading
+		// zero as octal.
+		base = 0
+	} else {
+		raw = strings.ReplaceAll(raw, "_", "")
+	}
+	if integer, err := strconv.ParseInt(raw, base, 64); err == nil {
+		return integer
+	}
+	if e.numbers == DecimalNumbers && base == 10 {
+		if decimal, ok := new(big.Rat).SetString(raw); ok {
+			return decimal
+		}
+	}
+	return expr.value
+}
+
+// parseNumber parses the lexeme of a number literal. Hexadecimal, octal and
+// binary integers, which ParseFloat does not read, are rounded to the
+// nearest float64.
+func parseNumber(lexeme string) (float64, error) {
+	number, err := strconv.ParseFloat(lexeme, 64)
+	if err == nil {
+		return number, nil
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("number %s is out of range", lexeme)
+	}
+	if hasBasePrefix(lexeme) {
+		if integer, ok := new(big.Int).SetString(lexeme, 0); ok {
+			number, _ = new(big.Float).SetInt(integer).Float64()
+			return number, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid number %q", lexeme)
+}
+
+// hasBasePrefix reports whether the lexeme of a number starts with 0x, 0o
+// or 0b.
+func hasBasePrefix(lexeme string) bool {
+	return len(lexeme) > 1 && lexeme[0] == '0' && strings.ContainsRune("xXoObB", rune(lexeme[1]))
+}
+
+// integer returns n as a number of the number mode of the evaluator.
+func (e *Evaluator) integer(n int) interface{} {
+	if e.numbers == FloatNumbers {
+		return float64(n)
+	}
+	return int64(n)
+}
+
+// promote converts two numbers to a common type: int64, *big.Rat or
+// float64. ok is false if either is not a number.
+func (e *Evaluator) promote(left, right interface{}) (l, r interface{}, ok bool) {
+	if !isNumber(left) || !isNumber(right) {
+		return nil, nil, false
+	}
+	if e.numbers != FloatNumbers {
+		if l, ok := toInt64(left); ok {
+			if r, ok := toInt64(right); ok {
+				return l, r, true
+			}
+		}
+		_, leftDecimal := left.(*big.Rat)
+		_, rightDecimal := right.(*big.Rat)
+		if leftDecimal || rightDecimal {
+			l, lok := toRat(left)
+			r, rok := toRat(right)
+			if lok && rok {
+				return l, r, true
+			}
+		}
+	}
+	leftFloat, _ := toFloat64(left)
+	rightFloat, _ := toFloat64(right)
+	return leftFloat, rightFloat, true
+}
+
+// arithmetic applies an arithmetic operator to two numbers promoted to a
+// common type. ok is false if either is not a number.
+func (e *Evaluator) arithmetic(operator TokenType, left, right interface{}) (value interface{}, ok bool, err error) {
+	l, r, ok := e.promote(left, right)
+	if !ok {
+		return nil, false, nil
+	}
+	switch l := l.(type) {
+	case int64:
+		value, err = e.integerArithmetic(operator, l, r.(int64))
+	case *big.Rat:
+		value, err = decimalArithmetic(operator, l, r.(*big.Rat))
+	case float64:
+		value, err = floatArithmetic(operator, l, r.(float64))
+	}
+	return value, true, err
+}
+
+func floatArithmetic(operator TokenType, l, r float64) (interface{}, error) {
+	switch operator {
+	case PLUS:
+		return l + r, nil
+	case MINUS:
+		return l - r, nil
+	case STAR:
+		return l * r, nil
+	case SLASH:
+		if r == 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %f / %f", l, r)
+		}
+		return l / r, nil
+	case PERCENT:
+		// Like javascript, the result takes the sign of the dividend.
+		if r == 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %f %% %f", l, r)
+		}
+		return math.Mod(l, r), nil
+	case SLASH_SLASH:
+		if r == 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %f // %f", l, r)
+		}
+		return math.Floor(l / r), nil
+	case STAR_STAR:
+		if l == 0 && r < 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %f ** %f", l, r)
+		}
+		return math.Pow(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown arithmetic operator %s", operator)
+}
+
+func (e *Evaluator) integerArithmetic(operator TokenType, l, r int64) (interface{}, error) {
+	overflow := func() error {
+		return fmt.Errorf("integer overflow: %d %s %d", l, operatorSymbol(operator), r)
+	}
+	switch operator {
+	case PLUS:
+		sum := l + r
+		if (r > 0 && sum < l) || (r < 0 && sum > l) {
+			return nil, overflow()
+		}
+		return sum, nil
+	case MINUS:
+		difference := l - r
+		if (r > 0 && difference > l) || (r < 0 && difference < l) {
+			return nil, overflow()
+		}
+		return difference, nil
+	case STAR:
+		product, ok := multiply(l, r)
+		if !ok {
+			return nil, overflow()
+		}
+		return product, nil
+	case SLASH:
+		if r == 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %d / %d", l, r)
+		}
+		if e.numbers == DecimalNumbers {
+			return big.NewRat(l, r), nil
+		}
+		return float64(l) / float64(r), nil
+	case PERCENT:
+		if r == 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %d %% %d", l, r)
+		}
+		return l % r, nil
+	case SLASH_SLASH:
+		if r == 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %d // %d", l, r)
+		}
+		if l == math.MinInt64 && r == -1 {
+			return nil, overflow()
+		}
+		quotient := l / r
+		if l%r != 0 && (l < 0) != (r < 0) {
+			quotient--
+		}
+		return quotient, nil
+	case STAR_STAR:
+		if r < 0 {
+			if l == 0 {
+				return nil, fmt.Errorf("cannot divide by zero: %d ** %d", l, r)
+			}
+			if e.numbers == DecimalNumbers {
+				return decimalArithmetic(operator, new(big.Rat).SetInt64(l), new(big.Rat).SetInt64(r))
+			}
+			return math.Pow(float64(l), float64(r)), nil
+		}
+		power := int64(1)
+		for base, exponent := l, r; exponent > 0; exponent >>= 1 {
+			var ok bool
+			if exponent&1 == 1 {
+				if power, ok = multiply(power, base); !ok {
+					return nil, overflow()
+				}
+			}
+			if exponent > 1 {
+				if base, ok = multiply(base, base); !ok {
+					return nil, overflow()
+				}
+			}
+		}
+		return power, nil
+	}
+	return nil, fmt.Errorf("unknown arithmetic operator %s", operator)
+}
+
+func decimalArithmetic(operator TokenType, l, r *big.Rat) (interface{}, error) {
+	switch operator {
+	case PLUS:
+		return new(big.Rat).Add(l, r), nil
+	case MINUS:
+		return new(big.Rat).Sub(l, r), nil
+	case STAR:
+		return new(big.Rat).Mul(l, r), nil
+	}
+	if r.Sign() == 0 && operator != STAR_STAR {
+		return nil, fmt.Errorf("cannot divide by zero: %s %s %s", formatDecimal(l), operatorSymbol(operator), formatDecimal(r))
+	}
+	switch operator {
+	case SLASH:
+		return new(big.Rat).Quo(l, r), nil
+	case PERCENT:
+		// l - r * trunc(l / r), so that the result takes the sign of the
+		// dividend.
+		quotient := new(big.Rat).Quo(l, r)
+		truncated := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+		return new(big.Rat).Sub(l, new(big.Rat).Mul(r, new(big.Rat).SetInt(truncated))), nil
+	case SLASH_SLASH:
+		quotient := new(big.Rat).Quo(l, r)
+		return new(big.Rat).SetInt(new(big.Int).Div(quotient.Num(), quotient.Denom())), nil
+	case STAR_STAR:
+		if !r.IsInt() || !r.Num().IsInt64() || r.Num().Int64() > maxExactExponent || r.Num().Int64() < -maxExactExponent {
+			lf, _ := l.Float64()
+			rf, _ := r.Float64()
+			return floatArithmetic(operator, lf, rf)
+		}
+		exponent := r.Num().Int64()
+		if l.Sign() == 0 && exponent < 0 {
+			return nil, fmt.Errorf("cannot divide by zero: %s ** %d", formatDecimal(l), exponent)
+		}
+		power := new(big.Rat).SetFrac(
+			new(big.Int).Exp(l.Num(), big.NewInt(abs(exponent)), nil),
+			new(big.Int).Exp(l.Denom(), big.NewInt(abs(exponent)), nil),
+		)
+		if exponent < 0 {
+			power.Inv(power)
+		}
+		return power, nil
+	}
+	return nil, fmt.Errorf("unknown arithmetic operator %s", operator)
+}
+
+// compareNumbers compares two numbers promoted to a common type. ok is false
+// if either is not a number, or is NaN.
+func (e *Evaluator) compareNumbers(left, right interface{}) (cmp int, ok bool) {
+	l, r, ok := e.promote(left, right)
+	if !ok {
+		return 0, false
+	}
+	switch l := l.(type) {
+	case int64:
+		r := r.(int64)
+		if l < r {
+			return -1, true
+		} else if l > r {
+			return 1, true
+		}
+		return 0, true
+	case *big.Rat:
+		return l.Cmp(r.(*big.Rat)), true
+	}
+	lf, rf := l.(float64), r.(float64)
+	switch {
+	case lf < rf:
+		return -1, true
+	case lf > rf:
+		return 1, true
+	case lf == rf:
+		return 0, true
+	}
+	return 0, false
+}
+
+// negate returns -value.
+func (e *Evaluator) negate(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return -v, nil
+	case *big.Rat:
+		return new(big.Rat).Neg(v), nil
+	case time.Duration:
+		if v == math.MinInt64 {
+			return nil, fmt.Errorf("duration overflow: -(%s)", v)
+		}
+		return -v, nil
+	}
+	if e.numbers != FloatNumbers {
+		if integer, ok := toInt64(value); ok {
+			if integer == math.MinInt64 {
+				return nil, fmt.Errorf("integer overflow: -(%d)", integer)
+			}
+			return -integer, nil
+		}
+	}
+	if number, err := toFloat64(value); err == nil {
+		return -number, nil
+	}
+	return nil, fmt.Errorf("cannot negate %T", value)
+}
+
+// toInt64 converts a value of any Go integer type to an int64. ok is false
+// for other types, and for unsigned integers above math.MaxInt64.
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), uint64(v) <= math.MaxInt64
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	}
+	return 0, false
+}
+
+// toInt converts a number to an int, truncating floats. ok is false if
+// value is not a number.
+func toInt(value interface{}) (int, bool) {
+	if integer, ok := toInt64(value); ok {
+		return int(integer), true
+	}
+	number, err := toFloat64(value)
+	if err != nil {
+		return 0, false
+	}
+	return int(number), true
+}
+
+// toRat converts a number to a *big.Rat. ok is false for NaN and infinities.
+func toRat(value interface{}) (*big.Rat, bool) {
+	if decimal, ok := value.(*big.Rat); ok {
+		return decimal, true
+	}
+	if integer, ok := toInt64(value); ok {
+		return new(big.Rat).SetInt64(integer), true
+	}
+	if unsigned, ok := value.(uint64); ok {
+		return new(big.Rat).SetInt(new(big.Int).SetUint64(unsigned)), true
+	}
+	number, err := toFloat64(value)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return nil, false
+	}
+	bits := 64
+	if _, ok := value.(float32); ok {
+		bits = 32
+	}
+	return new(big.Rat).SetString(strconv.FormatFloat(number, 'g', -1, bits))
+}
+
+// formatDecimal formats r in decimal notation. Decimals that have no finite
+// expansion are rounded to decimalDigits digits after the point.
+func formatDecimal(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	// A fraction has a finite decimal expansion if its denominator has no
+	// prime factors but 2 and 5, and then as many digits as the largest
+	// power of either.
+	denominator := new(big.Int).Set(r.Denom())
+	digits := 0
+	for _, factor := range []int64{2, 5} {
+		f, power := big.NewInt(factor), 0
+		modulus := new(big.Int)
+		for {
+			quotient, remainder := new(big.Int).QuoRem(denominator, f, modulus)
+			if remainder.Sign() != 0 {
+				break
+			}
+			denominator, power = quotient, power+1
+		}
+		if power > digits {
+			digits = power
+		}
+	}
+	if denominator.Cmp(big.NewInt(1)) == 0 {
+		return r.FloatString(digits)
+	}
+	return strings.TrimRight(r.FloatString(decimalDigits), "0")
+}
+
+// stringify formats a value as it appears in the output of a template.
+func stringify(value interface{}) string {
+	if decimal, ok := value.(*big.Rat); ok {
+		return formatDecimal(decimal)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// multiply returns l * r and whether it did not overflow.
+func multiply(l, r int64) (int64, bool) {
+	if l == 0 || r == 0 {
+		return 0, true
+	}
+	product := l * r
+	if product/r != l || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func operatorSymbol(operator TokenType) string {
+	switch operator {
+	case PLUS:
+		return "+"
+	case MINUS:
+		return "-"
+	case STAR:
+		return "*"
+	case SLASH:
+		return "/"
+	case PERCENT:
+		return "%"
+	case SLASH_SLASH:
+		return "//"
+	case STAR_STAR:
+		return "**"
+	}
+	return operator.String()
+}
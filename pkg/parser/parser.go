@@ -2,13 +2,46 @@ package parser
 
 import (
 	"fmt"
-	"strconv"
 )
 
 func NewParser(source string) *Parser {
-	lexer := NewLexer(source)
+	return NewParserWithOptions(source, ParserOptions{})
+}
+
+// NewParserWithOptions creates a parser for source using the given options.
+// This is useful for embedding templates in files that already make use of
+// the default delimiters.
+func NewParserWithOptions(source string, options ParserOptions) *Parser {
+	if options.LeftDelim == "" {
+		options.LeftDelim = DefaultLeftDelim
+	}
+	if options.RightDelim == "" {
+		options.RightDelim = DefaultRightDelim
+	}
+	lexer := NewLexerWithOptions(source, LexerOptions{
+		LeftDelim:  options.LeftDelim,
+		RightDelim: options.RightDelim,
+		Expression: options.Expression,
+	})
 	tokens := lexer.scanTokens()
-	return &Parser{tokens: tokens, current: 0}
+	return &Parser{
+		tokens:  tokens,
+		current: 0,
+		options: options,
+		source:  source,
+		lines:   lineOffsets(source),
+	}
+}
+
+// NewExpressionParser creates a parser for a bare expression that is not
+// wrapped in template delimiters.
+func NewExpressionParser(source string) *Parser {
+	return NewParserWithOptions(source, ParserOptions{Expression: true})
+}
+
+// ParseExpression parses source as a single bare expression.
+func ParseExpression(source string) Expr {
+	return NewExpressionParser(source).Parse()
 }
 
 func (p *Parser) Parse() (exp Expr) {
@@ -17,28 +50,60 @@ func (p *Parser) Parse() (exp Expr) {
 			if err, ok := r.(*ParseError); ok {
 				exp = err
 			} else {
-				exp = &ParseError{
-					message: fmt.Sprintf("%+v", r),
-					token:   p.peek(),
-				}
+				exp = p.newError(fmt.Sprintf("%+v", r), "", p.peek())
 			}
 		}
 	}()
+	if p.options.Expression {
+		return p.bareExpression()
+	}
 	return p.template()
 }
 
+// ParseAll parses the source like Parse, but does not stop at the first
+// syntax error. It skips to the end of the failing action, argument, array
+// element or map value and carries on, so that every error in the source is
+// reported. Fragments that failed to parse are replaced by their *ParseError
+// in the returned AST.
+func (p *Parser) ParseAll() (Expr, ErrorList) {
+	p.recovering = true
+	p.errors = nil
+	defer func() {
+		p.recovering = false
+	}()
+	expr := p.Parse()
+	if err, ok := expr.(*ParseError); ok {
+		p.report(err)
+	}
+	return expr, p.errors
+}
+
+// Grammar:
+// bareExpression → expression EOF ;
+func (p *Parser) bareExpression() Expr {
+	expr := p.recoverable(p.expression)
+	if !p.isAtEnd() {
+		err := p.newError(fmt.Sprintf("Expect end of expression. got %v", p.peek().lexeme), "end of expression", p.peek())
+		if !p.recovering {
+			panic(err)
+		}
+		p.report(err)
+	}
+	return expr
+}
+
 // Grammar:
 // template  → ( valueTemplate | TEXT )* ;
 func (p *Parser) template() Expr {
 	var exprs []Expr
 	for !p.isAtEnd() {
 		if p.match(TEMPLATE_LEFT_BRACE) {
-			exprs = append(exprs, p.valueTemplate())
+			exprs = append(exprs, p.recoverable(p.valueTemplate, TEMPLATE_RIGHT_BRACE))
 		} else {
 			exprs = append(exprs, p.text())
 		}
 	}
-	return NewTemplate(exprs)
+	return p.finish(NewTemplate(exprs), 0)
 }
 
 // Grammar:
@@ -48,7 +113,8 @@ func (p *Parser) valueTemplate() Expr {
 
 	if ok := p.consume(TEMPLATE_RIGHT_BRACE); !ok {
 		p.error(
-			fmt.Sprintf("Expect '}}' after expression. got %v", p.peek().lexeme),
+			fmt.Sprintf("Expect '%s' after expression. got %v", p.options.RightDelim, p.peek().lexeme),
+			fmt.Sprintf("'%s'", p.options.RightDelim),
 			p.peek(),
 		)
 	}
@@ -59,21 +125,78 @@ func (p *Parser) valueTemplate() Expr {
 // TEXT → [^\{\}]+ ;
 func (p *Parser) text() Expr {
 	token := p.advance()
-	return NewLiteral(token.lexeme, token.lexeme)
+	return p.finish(NewLiteral(token.lexeme, token.lexeme), token.start)
 }
 
 // Grammar:
-// expression  → nullCoalescing ;
+// expression  → lambda | nullCoalescing ;
 func (p *Parser) expression() Expr {
+	p.nest()
+	defer p.unnest()
+	if p.isLambda() {
+		return p.lambda()
+	}
 	return p.nullCoalescing()
 }
 
 // Grammar:
-// nullCoalescing → ternary ( NULLCOALESCING ternary )* ;
+// lambda → ( IDENTIFIER | LPAREN parameters? RPAREN ) ARROW expression ;
+// parameters → IDENTIFIER ( COMMA IDENTIFIER )* ;
+func (p *Parser) lambda() Expr {
+	start := p.peek().start
+	params := make([]Token, 0)
+	if p.match(IDENTIFIER) {
+		params = append(params, p.previous())
+	} else {
+		p.advance() // The opening parenthesis.
+		for p.match(IDENTIFIER) {
+			param := p.previous()
+			for _, other := range params {
+				if other.lexeme == param.lexeme {
+					p.error(fmt.Sprintf("Duplicate parameter '%s'.", param.lexeme), "", param)
+				}
+			}
+			params = append(params, param)
+			p.match(COMMA)
+		}
+		p.advance() // The closing parenthesis.
+	}
+	p.advance() // The arrow.
+	return p.finish(NewLambda(params, p.expression()), start)
+}
+
+// isLambda reports whether the next tokens are the parameters of a lambda
+// and its arrow. Whether `(a)` is a lambda or a grouping cannot be told
+// before the arrow.
+func (p *Parser) isLambda() bool {
+	at := func(offset int) TokenType {
+		if p.current+offset >= len(p.tokens) {
+			return EOF
+		}
+		return p.tokens[p.current+offset].tokenType
+	}
+	switch at(0) {
+	case IDENTIFIER:
+		return at(1) == ARROW
+	case LEFT_PAREN:
+		offset := 1
+		if at(offset) == IDENTIFIER {
+			offset++
+			for at(offset) == COMMA && at(offset+1) == IDENTIFIER {
+				offset += 2
+			}
+		}
+		return at(offset) == RIGHT_PAREN && at(offset+1) == ARROW
+	}
+	return false
+}
+
+// Grammar:
+// nullCoalescing → ternary ( ( NULLCOALESCING | FALSY_COALESCING ) ternary )* ;
 func (p *Parser) nullCoalescing() Expr {
 	expr := p.ternary()
-	for p.match(NULLCOALESCING) {
-		expr = NewBinary(expr, p.previous(), p.ternary())
+	for p.match(NULLCOALESCING, FALSY_COALESCING) {
+		expr = p.finish(NewBinary(expr, p.previous(), p.ternary()), expr.Span().Start.Offset)
 	}
 	return expr
 }
@@ -87,11 +210,12 @@ func (p *Parser) ternary() Expr {
 		if ok := p.consume(COLON); !ok {
 			p.error(
 				fmt.Sprintf("Expect ':' after true expression. got %v", p.peek().lexeme),
+				"':'",
 				p.peek(),
 			)
 		}
 		falseExpr := p.expression()
-		expr = NewTernary(expr, trueExpr, falseExpr)
+		expr = p.finish(NewTernary(expr, trueExpr, falseExpr), expr.Span().Start.Offset)
 	}
 	return expr
 }
@@ -103,66 +227,135 @@ func (p *Parser) logicalOr() Expr {
 	for p.match(OR) {
 		operator := p.previous()
 		right := p.logicalAnd()
-		expr = NewBinary(expr, operator, right)
+		expr = p.finish(NewBinary(expr, operator, right), expr.Span().Start.Offset)
 	}
 	return expr
 }
 
 // Grammar:
-// logicalAnd  → equality ( AND equality )* ;
+// logicalAnd  → logicalNot ( AND logicalNot )* ;
 func (p *Parser) logicalAnd() Expr {
-	expr := p.equality()
+	expr := p.logicalNot()
 	for p.match(AND) {
 		operator := p.previous()
-		right := p.equality()
-		expr = NewBinary(expr, operator, right)
+		right := p.logicalNot()
+		expr = p.finish(NewBinary(expr, operator, right), expr.Span().Start.Offset)
 	}
 	return expr
 }
 
+// Grammar:
+// logicalNot  → NOT logicalNot | equality ;
+//
+// Unlike `!`, the `not` keyword binds looser than comparisons, as it does in
+// python, so `not a in b` reads as `not (a in b)`.
+func (p *Parser) logicalNot() Expr {
+	if p.match(NOT) {
+		p.nest()
+		defer p.unnest()
+		operator := p.previous()
+		return p.finish(NewUnary(operator, p.logicalNot()), operator.start)
+	}
+	return p.equality()
+}
+
 // Grammar:
 // equality  → comparison ( ( BANG_EQUAL | EQUAL_EQUAL ) comparison )* ;
 func (p *Parser) equality() Expr {
-	return p.comparison()
+	expr := p.comparison()
+	for p.match(BANG_EQUAL, EQUAL_EQUAL) {
+		expr = p.finish(NewBinary(expr, p.previous(), p.comparison()), expr.Span().Start.Offset)
+	}
+	return expr
 }
 
 // Grammar:
-// comparison  → term ( ( GREATER | GREATER_EQUAL | LESS | LESS_EQUAL ) term )* ;
+// comparison  → term ( ( GREATER | GREATER_EQUAL | LESS | LESS_EQUAL | IN | NOT IN ) term )* ;
+//
+// When ParserOptions.ChainedComparisons is set, a run of comparisons such as
+// `0 < x <= 10` becomes a single Comparison that holds when every adjacent
+// pair holds, like it does in python.
 func (p *Parser) comparison() Expr {
 	expr := p.term()
-	for p.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, BANG_EQUAL, EQUAL_EQUAL) {
-		expr = NewBinary(expr, p.previous(), p.term())
+	if p.options.ChainedComparisons {
+		operands := []Expr{expr}
+		operators := make([]Token, 0)
+		for operator, ok := p.comparisonOperator(); ok; operator, ok = p.comparisonOperator() {
+			operators = append(operators, operator)
+			operands = append(operands, p.term())
+		}
+		switch len(operators) {
+		case 0:
+			return expr
+		case 1:
+			return p.finish(NewBinary(operands[0], operators[0], operands[1]), expr.Span().Start.Offset)
+		default:
+			return p.finish(NewComparison(operands, operators), expr.Span().Start.Offset)
+		}
+	}
+	for operator, ok := p.comparisonOperator(); ok; operator, ok = p.comparisonOperator() {
+		expr = p.finish(NewBinary(expr, operator, p.term()), expr.Span().Start.Offset)
 	}
 	return expr
 }
 
+// comparisonOperator consumes the next comparison operator, if any. The two
+// keywords of `not in` are merged into a single NOT_IN token.
+func (p *Parser) comparisonOperator() (Token, bool) {
+	if p.match(GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, IN) {
+		return p.previous(), true
+	}
+	if p.check(NOT) && p.tokens[p.current+1].tokenType == IN {
+		not := p.advance()
+		p.advance()
+		return Token{lexeme: "not in", tokenType: NOT_IN, start: not.start, line: not.line}, true
+	}
+	return Token{}, false
+}
+
 // Grammar:
 // term  → factor ( ( MINUS | PLUS ) factor )* ;
 func (p *Parser) term() Expr {
 	expr := p.factor()
 	for p.match(MINUS, PLUS) {
-		expr = NewBinary(expr, p.previous(), p.factor())
+		expr = p.finish(NewBinary(expr, p.previous(), p.factor()), expr.Span().Start.Offset)
 	}
 	return expr
 }
 
 // Grammar:
-// factor  → unary ( ( SLASH | STAR ) unary )* ;
+// factor  → unary ( ( SLASH | STAR | PERCENT | SLASH_SLASH ) unary )* ;
 func (p *Parser) factor() Expr {
 	expr := p.unary()
-	for p.match(SLASH, STAR) {
-		expr = NewBinary(expr, p.previous(), p.unary())
+	for p.match(SLASH, STAR, PERCENT, SLASH_SLASH) {
+		expr = p.finish(NewBinary(expr, p.previous(), p.unary()), expr.Span().Start.Offset)
 	}
 	return expr
 }
 
 // Grammar:
-// unary  → ( BANG | MINUS ) unary | call ;
+// unary  → ( BANG | MINUS ) unary | power ;
 func (p *Parser) unary() Expr {
 	if p.match(BANG, MINUS) {
-		return NewUnary(p.previous(), p.unary())
+		p.nest()
+		defer p.unnest()
+		operator := p.previous()
+		return p.finish(NewUnary(operator, p.unary()), operator.start)
 	}
-	return p.call()
+	return p.power()
+}
+
+// Grammar:
+// power  → call ( STAR_STAR unary )? ;
+//
+// The right operand is parsed as a unary so that `**` is right-associative
+// and binds tighter than a unary operator on its left: -2 ** 2 is -(2 ** 2).
+func (p *Parser) power() Expr {
+	expr := p.call()
+	if p.match(STAR_STAR) {
+		expr = p.finish(NewBinary(expr, p.previous(), p.unary()), expr.Span().Start.Offset)
+	}
+	return expr
 }
 
 // Grammar:
@@ -175,7 +368,7 @@ func (p *Parser) call() Expr {
 		} else if p.match(DOT) {
 			expr = p.get(expr)
 		} else if p.match(OPTIONALCHAIN) {
-			expr = NewOptional(expr)
+			expr = p.finish(NewOptional(expr), expr.Span().Start.Offset)
 			if p.match(LEFT_PAREN) {
 				expr = p.finishCall(expr)
 			} else if p.check(IDENTIFIER) {
@@ -198,80 +391,91 @@ func (p *Parser) call() Expr {
 // get →  IDENTIFIER ;
 func (p *Parser) get(expr Expr) Expr {
 	if ok := p.consume(IDENTIFIER); !ok {
-		p.error(fmt.Sprintf("Expect property name after '%s'. got %v", p.previous().lexeme, p.peek().lexeme), p.peek())
+		p.error(fmt.Sprintf("Expect property name after '%s'. got %v", p.previous().lexeme, p.peek().lexeme), "property name", p.peek())
 	}
-	return NewGet(expr, p.previous())
+	return p.finish(NewGet(expr, p.previous()), expr.Span().Start.Offset)
 }
 
 // Grammar:
 // index → LBRACKET expression RBRACKET ;
 func (p *Parser) index(expr Expr) Expr {
-	index := p.expression()
+	index := p.recoverable(p.expression)
 	if ok := p.consume(RIGHT_BRACKET); !ok {
 		p.error(
 			fmt.Sprintf("Expect ']' after index expression. got %v", p.peek().lexeme),
+			"']'",
 			p.peek(),
 		)
 	}
-	return NewIndex(expr, index)
+	return p.finish(NewIndex(expr, index), expr.Span().Start.Offset)
 }
 
 func (p *Parser) finishCall(expr Expr) Expr {
 	args := make([]Expr, 0)
 	if !p.check(RIGHT_PAREN) {
-		args = append(args, p.expression())
+		args = append(args, p.recoverable(p.expression))
 		for p.match(COMMA) {
-			args = append(args, p.expression())
+			args = append(args, p.recoverable(p.expression))
 		}
 	}
 	if ok := p.consume(RIGHT_PAREN); !ok {
-		p.error(fmt.Sprintf("Expect ')' after arguments. got %v", p.peek().lexeme), p.peek())
+		p.error(fmt.Sprintf("Expect ')' after arguments. got %v", p.peek().lexeme), "')'", p.peek())
 	}
-	return NewCall(expr, args)
+	return p.finish(NewCall(expr, args), expr.Span().Start.Offset)
 }
 
 // Grammar:
-// primary  → "true" | "false" | "nil" | NUMBER | STRING | IDENTIFIER | LPAREN expression RPAREN | array | map;
+// primary  → "true" | "false" | "nil" | NUMBER | DURATION | STRING | IDENTIFIER | LPAREN expression RPAREN | array | map;
 func (p *Parser) primary() Expr {
+	start := p.peek().start
 	if p.match(FALSE) {
-		return NewLiteral(false, "false")
+		return p.finish(NewLiteral(false, "false"), start)
 	}
 	if p.match(TRUE) {
-		return NewLiteral(true, "true")
+		return p.finish(NewLiteral(true, "true"), start)
 	}
 	if p.match(NIL) {
-		return NewLiteral(nil, "nil")
+		return p.finish(NewLiteral(nil, "nil"), start)
 	}
 	if p.match(NUMBER) {
-		num, _ := strconv.ParseFloat(p.previous().lexeme, 64)
-
-		return NewLiteral(num, p.previous().lexeme)
+		num, err := parseNumber(p.previous().lexeme)
+		if err != nil {
+			p.error(fmt.Sprintf("Invalid number. %s", err), "number", p.previous())
+		}
+		return p.finish(NewLiteral(num, p.previous().lexeme), start)
+	}
+	if p.match(DURATION) {
+		duration, err := parseDuration(p.previous().lexeme)
+		if err != nil {
+			p.error(fmt.Sprintf("Invalid duration. %s", err), "duration", p.previous())
+		}
+		return p.finish(NewLiteral(duration, p.previous().lexeme), start)
 	}
 	if p.match(IDENTIFIER) {
-		return NewVariable(p.previous())
+		return p.finish(NewVariable(p.previous()), start)
 	}
 	if p.match(STRING) {
-		str := p.previous().lexeme
-		return NewLiteral(str[1:len(str)-1], p.previous().lexeme)
+		return p.finish(NewLiteral(p.unquote(p.previous()), p.previous().lexeme), start)
 	}
 	if p.match(LEFT_PAREN) {
-		expr := p.expression()
+		expr := p.recoverable(p.expression)
 		if ok := p.consume(RIGHT_PAREN); !ok {
 			p.error(
 				fmt.Sprintf("Expect ')' after expression. got %v", p.peek().lexeme),
+				"')'",
 				p.peek(),
 			)
 		}
-		return NewGrouping(expr)
+		return p.finish(NewGrouping(expr), start)
 	}
 	if p.match(LEFT_BRACKET) {
-		return p.array()
+		return p.finish(p.array(), start)
 	}
 	if p.match(LEFT_BRACE) {
-		return p.mapExpr()
+		return p.finish(p.mapExpr(), start)
 	}
 
-	p.error(fmt.Sprintf("Expect expression. got %v", p.peek().lexeme), p.peek())
+	p.error(fmt.Sprintf("Expect expression. got %v", p.peek().lexeme), "expression", p.peek())
 	return nil
 }
 
@@ -280,14 +484,15 @@ func (p *Parser) primary() Expr {
 func (p *Parser) array() Expr {
 	values := make([]Expr, 0)
 	if !p.check(RIGHT_BRACKET) {
-		values = append(values, p.expression())
+		values = append(values, p.recoverable(p.expression))
 		for p.match(COMMA) {
-			values = append(values, p.expression())
+			values = append(values, p.recoverable(p.expression))
 		}
 	}
 	if ok := p.consume(RIGHT_BRACKET); !ok {
 		p.error(
 			fmt.Sprintf("Expect ']' after array expression. got %v", p.peek().lexeme),
+			"']'",
 			p.peek(),
 		)
 	}
@@ -308,6 +513,7 @@ func (p *Parser) mapExpr() Expr {
 	if ok := p.consume(RIGHT_BRACE); !ok {
 		p.error(
 			fmt.Sprintf("Expect '}' after map expression. got %v", p.peek().lexeme),
+			"'}'",
 			p.peek(),
 		)
 	}
@@ -318,17 +524,18 @@ func (p *Parser) mapExpr() Expr {
 // Grammar:
 // mapEntry  → ( identifier | string | LBRACKET expression RBRACKET ) COLON expression ;
 func (p *Parser) mapEntry() *MapEntry {
+	start := p.peek().start
 	var key Expr
 	if p.match(IDENTIFIER) {
-		key = NewLiteral(p.previous().lexeme, p.previous().lexeme)
+		key = p.finish(NewLiteral(p.previous().lexeme, p.previous().lexeme), start)
 	} else if p.match(STRING) {
-		str := p.previous().lexeme
-		key = NewLiteral(str[1:len(str)-1], str)
+		key = p.finish(NewLiteral(p.unquote(p.previous()), p.previous().lexeme), start)
 	} else if p.match(LEFT_BRACKET) {
 		key = p.expression()
 		if ok := p.consume(RIGHT_BRACKET); !ok {
 			p.error(
 				fmt.Sprintf("Expect ']' after index expression. got %v", p.peek().lexeme),
+				"']'",
 				p.peek(),
 			)
 		}
@@ -336,17 +543,92 @@ func (p *Parser) mapEntry() *MapEntry {
 	if key == nil {
 		p.error(
 			fmt.Sprintf("Expect map key. got %v", p.peek().lexeme),
+			"map key",
 			p.peek(),
 		)
 	}
 	if ok := p.consume(COLON); !ok {
 		p.error(
 			fmt.Sprintf("Expect ':' after map key. got %v", p.peek().lexeme),
+			"':'",
 			p.peek(),
 		)
 	}
-	value := p.expression()
-	return NewMapEntry(key, value)
+	value := p.recoverable(p.expression)
+	entry := NewMapEntry(key, value)
+	p.finish(entry, start)
+	return entry
+}
+
+func (p *Parser) unquote(token Token) string {
+	str, err := unquote(token.lexeme)
+	if err != nil {
+		p.error(err.Error(), "", token)
+	}
+	return str
+}
+
+// recoverable runs parse. When the parser is recovering from errors and parse
+// fails, the error is reported and the parser skips ahead to the next `,`,
+// closing bracket or closing delimiter at the same nesting level. The stop
+// token is consumed only if it is one of consume. The error takes the place
+// of the expression that failed.
+func (p *Parser) recoverable(parse func() Expr, consume ...TokenType) (expr Expr) {
+	if !p.recovering {
+		return parse()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*ParseError)
+			if !ok {
+				panic(r)
+			}
+			p.report(err)
+			p.synchronize()
+			for _, tokenType := range consume {
+				if p.match(tokenType) {
+					break
+				}
+			}
+			expr = err
+		}
+	}()
+	return parse()
+}
+
+// synchronize skips tokens until the next `,`, closing bracket or closing
+// delimiter that is not nested in a bracket opened after the error.
+func (p *Parser) synchronize() {
+	depth := 0
+	for !p.isAtEnd() {
+		switch p.peek().tokenType {
+		case LEFT_PAREN, LEFT_BRACKET, LEFT_BRACE:
+			depth++
+		case RIGHT_PAREN, RIGHT_BRACKET, RIGHT_BRACE:
+			if depth == 0 {
+				return
+			}
+			depth--
+		case COMMA:
+			if depth == 0 {
+				return
+			}
+		case TEMPLATE_RIGHT_BRACE:
+			return
+		}
+		p.advance()
+	}
+}
+
+// report records err unless an error was already reported at the same
+// token, which happens when one mistake trips up several grammar rules.
+func (p *Parser) report(err *ParseError) {
+	for _, reported := range p.errors {
+		if reported == err || reported.Offset == err.Offset {
+			return
+		}
+	}
+	p.errors = append(p.errors, err)
 }
 
 func (p *Parser) consume(tokenType TokenType) bool {
@@ -357,8 +639,58 @@ func (p *Parser) consume(tokenType TokenType) bool {
 	return false
 }
 
-func (p *Parser) error(errorMessage string, token Token) {
-	panic(&ParseError{message: errorMessage, token: token})
+// error aborts parsing with a ParseError at token. expected describes what
+// the grammar expected to find instead, if anything.
+func (p *Parser) error(errorMessage string, expected string, token Token) {
+	panic(p.newError(errorMessage, expected, token))
+}
+
+// nest records that the parser enters a nested expression, and fails if
+// that exceeds ParserOptions.MaxDepth.
+func (p *Parser) nest() {
+	p.depth++
+	if p.options.MaxDepth > 0 && p.depth > p.options.MaxDepth {
+		limit := &DepthLimitError{Limit: p.options.MaxDepth}
+		err := p.newError(fmt.Sprintf("Expression is nested too deeply. %s", limit), "", p.peek())
+		err.Err = limit
+		p.depth--
+		panic(err)
+	}
+}
+
+func (p *Parser) unnest() {
+	p.depth--
+}
+
+func (p *Parser) newError(errorMessage string, expected string, token Token) *ParseError {
+	position := p.position(token.start)
+	err := &ParseError{
+		Message:  errorMessage,
+		Token:    token,
+		Expected: expected,
+		Offset:   position.Offset,
+		Line:     position.Line,
+		Column:   position.Column,
+	}
+	err.setSpan(Span{Start: position, End: p.position(token.end())})
+	return err
+}
+
+// finish records that expr was parsed from the source between the byte
+// offset start and the end of the last consumed token.
+func (p *Parser) finish(expr Expr, start int) Expr {
+	if n, ok := expr.(interface{ setSpan(Span) }); ok {
+		end := start
+		if p.current > 0 && p.previous().end() > start {
+			end = p.previous().end()
+		}
+		n.setSpan(Span{Start: p.position(start), End: p.position(end)})
+	}
+	return expr
+}
+
+func (p *Parser) position(offset int) Position {
+	return positionFor(p.source, p.lines, offset)
 }
 
 func (p *Parser) match(types ...TokenType) bool {
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// Position is a location in the source of a template.
+	Position struct {
+		// Offset is the byte offset, starting at 0.
+		Offset int
+		// Line is the line number, starting at 1.
+		Line int
+		// Column is the column number in runes, starting at 1.
+		Column int
+	}
+
+	// Span is the part of the source a node was parsed from. End is exclusive.
+	Span struct {
+		Start Position
+		End   Position
+	}
+
+	// node records the span of an expression. It is embedded by every Expr
+	// built by the parser.
+	node struct {
+		span Span
+	}
+)
+
+func (n *node) Span() Span {
+	return n.span
+}
+
+func (n *node) setSpan(span Span) {
+	n.span = span
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// lineOffsets returns the byte offset at which each line of source starts.
+func lineOffsets(source string) []int {
+	lines := []int{0}
+	for offset, r := range source {
+		if r == '\n' {
+			lines = append(lines, offset+1)
+		}
+	}
+	return lines
+}
+
+func positionFor(source string, lines []int, offset int) Position {
+	if offset > len(source) {
+		offset = len(source)
+	}
+	line := sort.Search(len(lines), func(i int) bool { return lines[i] > offset }) - 1
+	if line < 0 {
+		line = 0
+	}
+	return Position{
+		Offset: offset,
+		Line:   line + 1,
+		Column: utf8.RuneCountInString(source[lines[line]:offset]) + 1,
+	}
+}
+
+// formatSnippet renders message followed by the line of source that span
+// starts on, with the span underlined by carets.
+func formatSnippet(source string, span Span, message string) string {
+	lines := strings.Split(source, "\n")
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%s: %s\n", span.Start, message))
+	if span.Start.Line < 1 || span.Start.Line > len(lines) {
+		return builder.String()
+	}
+	line := strings.TrimRight(lines[span.Start.Line-1], "\r")
+	builder.WriteString(line)
+	builder.WriteString("\n")
+
+	width := 1
+	if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		width = span.End.Column - span.Start.Column
+	} else if span.End.Line > span.Start.Line {
+		width = utf8.RuneCountInString(line) - span.Start.Column + 1
+	}
+	column := 1
+	for _, r := range line {
+		if column >= span.Start.Column {
+			break
+		}
+		// keep tabs so that the carets line up with the source
+		if r == '\t' {
+			builder.WriteRune('\t')
+		} else {
+			builder.WriteRune(' ')
+		}
+		column++
+	}
+	if width < 1 {
+		width = 1
+	}
+	builder.WriteString(strings.Repeat("^", width))
+	builder.WriteString("\n")
+	return builder.String()
+}
@@ -0,0 +1,404 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Operator precedence levels used by the printer to decide where
+// parentheses are needed, from the loosest to the tightest binding.
+const (
+	precLowest = iota
+	precCoalescing
+	precTernary
+	precOr
+	precAnd
+	precNot
+	precEquality
+	precComparison
+	precTerm
+	precFactor
+	precUnary
+	precPower
+	precPostfix
+	precPrimary
+)
+
+type (
+	// PrinterOptions configures PrintWithOptions. Empty delimiters fall back
+	// to DefaultLeftDelim and DefaultRightDelim.
+	PrinterOptions struct {
+		LeftDelim  string
+		RightDelim string
+		// Indent is used once per nesting level when an array or map
+		// literal is split over several lines. Defaults to a tab.
+		Indent string
+		// LineWidth is the length above which array and map literals are
+		// split over several lines. Defaults to 80.
+		LineWidth int
+	}
+
+	printer struct {
+		options PrinterOptions
+		level   int
+	}
+)
+
+// Print turns expr back into canonical source: operators are surrounded by
+// single spaces, strings use double quotes and only the parentheses needed
+// to preserve the structure of the tree are kept. Parsing the output yields
+// the same tree as expr. Templates are printed with the default delimiters.
+func Print(expr Expr) (string, error) {
+	return PrintWithOptions(expr, PrinterOptions{})
+}
+
+// PrintWithOptions is like Print, but lays the source out according to options.
+func PrintWithOptions(expr Expr, options PrinterOptions) (out string, err error) {
+	if options.LeftDelim == "" {
+		options.LeftDelim = DefaultLeftDelim
+	}
+	if options.RightDelim == "" {
+		options.RightDelim = DefaultRightDelim
+	}
+	if options.Indent == "" {
+		options.Indent = "\t"
+	}
+	if options.LineWidth <= 0 {
+		options.LineWidth = 80
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if printErr, ok := r.(error); ok {
+				err = printErr
+				return
+			}
+			panic(r)
+		}
+	}()
+	p := &printer{options: options}
+	if template, ok := expr.(*Template); ok {
+		return p.template(template), nil
+	}
+	return p.expr(expr, precLowest), nil
+}
+
+func (p *printer) template(template *Template) string {
+	builder := strings.Builder{}
+	for _, e := range template.expressions {
+		if literal, ok := e.(*Literal); ok && isText(literal) {
+			builder.WriteString(literal.raw)
+			continue
+		}
+		builder.WriteString(p.options.LeftDelim)
+		builder.WriteString(" ")
+		builder.WriteString(p.expr(e, precLowest))
+		builder.WriteString(" ")
+		builder.WriteString(p.options.RightDelim)
+	}
+	return builder.String()
+}
+
+// expr prints e, wrapped in parentheses if it binds looser than prec.
+func (p *printer) expr(e Expr, prec int) string {
+	for {
+		grouping, ok := e.(*Grouping)
+		if !ok {
+			break
+		}
+		e = grouping.expression
+	}
+	str, own := p.print(e)
+	if own < prec {
+		return "(" + str + ")"
+	}
+	return str
+}
+
+// print returns the source of e and the precedence of its outermost operator.
+func (p *printer) print(e Expr) (string, int) {
+	switch e := e.(type) {
+	case *Literal:
+		return p.literal(e), precPrimary
+	case *Variable:
+		return e.name.lexeme, precPrimary
+	case *Unary:
+		if e.operator.tokenType == NOT {
+			return "not " + p.expr(e.right, precNot), precNot
+		}
+		return e.operator.lexeme + p.expr(e.right, precUnary), precUnary
+	case *Binary:
+		return p.binary(e)
+	case *Comparison:
+		parts := make([]string, 0, len(e.operands)*2)
+		for i, operand := range e.operands {
+			if i > 0 {
+				parts = append(parts, e.operators[i-1].lexeme)
+			}
+			parts = append(parts, p.expr(operand, precTerm))
+		}
+		return strings.Join(parts, " "), precComparison
+	case *Ternary:
+		return fmt.Sprintf(
+			"%s ? %s : %s",
+			p.expr(e.condition, precOr),
+			p.expr(e.trueExpr, precLowest),
+			p.expr(e.falseExpr, precLowest),
+		), precTernary
+	case *Get:
+		object, optional := p.object(e.object)
+		if optional {
+			return object + "?." + e.name.lexeme, precPostfix
+		}
+		return object + "." + e.name.lexeme, precPostfix
+	case *Index:
+		object, optional := p.object(e.object)
+		if optional {
+			object += "?."
+		}
+		return object + "[" + p.expr(e.index, precLowest) + "]", precPostfix
+	case *Call:
+		callee, optional := p.object(e.callee)
+		if optional {
+			callee += "?."
+		}
+		args := make([]string, len(e.arguments))
+		for i, arg := range e.arguments {
+			args[i] = p.expr(arg, precLowest)
+		}
+		return callee + "(" + strings.Join(args, ", ") + ")", precPostfix
+	case *Optional:
+		object, _ := p.object(e)
+		return object + "?.", precPostfix
+	case *Array:
+		return p.list("[", "]", len(e.values), func(i int) string {
+			return p.expr(e.values[i], precLowest)
+		}), precPrimary
+	case *Map:
+		return p.list("{", "}", len(e.entries), func(i int) string {
+			return p.mapEntry(e.entries[i])
+		}), precPrimary
+	case *MapEntry:
+		return p.mapEntry(e), precPrimary
+	case *Lambda:
+		params := make([]string, len(e.params))
+		for i, param := range e.params {
+			params[i] = param.lexeme
+		}
+		if len(params) == 1 {
+			return params[0] + " => " + p.expr(e.body, precLowest), precLowest
+		}
+		return "(" + strings.Join(params, ", ") + ") => " + p.expr(e.body, precLowest), precLowest
+	case *ParseError:
+		panic(fmt.Errorf("cannot print an expression that failed to parse: %w", e))
+	}
+	panic(fmt.Errorf("cannot print expression of type %T", e))
+}
+
+func (p *printer) binary(e *Binary) (string, int) {
+	operator := e.operator.lexeme
+	var prec, left, right int
+	switch e.operator.tokenType {
+	case NULLCOALESCING, FALSY_COALESCING:
+		return p.closed(e.left) + " " + operator + " " + p.expr(e.right, precTernary), precCoalescing
+	case OR:
+		prec, left, right = precOr, precOr, precAnd
+	case AND:
+		prec, left, right = precAnd, precAnd, precNot
+	case EQUAL_EQUAL, BANG_EQUAL:
+		prec, left, right = precEquality, precEquality, precComparison
+	case GREATER, GREATER_EQUAL, LESS, LESS_EQUAL, IN, NOT_IN:
+		// Comparisons are never chained on the left, so that the output
+		// means the same whether or not chained comparisons are enabled.
+		prec, left, right = precComparison, precTerm, precTerm
+		operator = canonicalOperator(e.operator)
+	case PLUS, MINUS:
+		prec, left, right = precTerm, precTerm, precFactor
+	case STAR, SLASH, PERCENT, SLASH_SLASH:
+		prec, left, right = precFactor, precFactor, precUnary
+	case STAR_STAR:
+		prec, left, right = precPower, precPostfix, precUnary
+	default:
+		panic(fmt.Errorf("cannot print binary operator %s", e.operator.tokenType))
+	}
+	return p.expr(e.left, left) + " " + operator + " " + p.expr(e.right, right), prec
+}
+
+// closed prints the left operand of a coalescing operator. The false branch
+// of a ternary extends as far right as possible, so a ternary that is
+// followed by another operand has to be parenthesized.
+func (p *printer) closed(e Expr) string {
+	for {
+		grouping, ok := e.(*Grouping)
+		if !ok {
+			break
+		}
+		e = grouping.expression
+	}
+	switch e := e.(type) {
+	case *Ternary:
+		return "(" + p.expr(e, precLowest) + ")"
+	case *Binary:
+		if e.operator.tokenType == NULLCOALESCING || e.operator.tokenType == FALSY_COALESCING {
+			return p.closed(e.left) + " " + e.operator.lexeme + " " + p.closed(e.right)
+		}
+	}
+	return p.expr(e, precCoalescing)
+}
+
+// object prints the left-hand side of a property access, index or call. It
+// reports whether the access goes through the optional chaining operator.
+func (p *printer) object(e Expr) (string, bool) {
+	optional, ok := e.(*Optional)
+	if ok {
+		e = optional.left
+	}
+	for {
+		grouping, isGrouping := e.(*Grouping)
+		if !isGrouping {
+			break
+		}
+		e = grouping.expression
+	}
+	if literal, isLiteral := e.(*Literal); isLiteral && isNumber(literal.value) {
+		// `1.length` would be read as a malformed number.
+		return "(" + p.literal(literal) + ")", ok
+	}
+	return p.expr(e, precPostfix), ok
+}
+
+func (p *printer) mapEntry(entry *MapEntry) string {
+	value := p.expr(entry.value, precLowest)
+	if literal, ok := entry.key.(*Literal); ok {
+		if key, ok := literal.value.(string); ok {
+			if isIdentifier(key) {
+				return key + ": " + value
+			}
+			return quote(key) + ": " + value
+		}
+	}
+	return "[" + p.expr(entry.key, precLowest) + "]: " + value
+}
+
+// list prints the n elements of an array or map literal on one line if they
+// fit, or on one line each otherwise.
+func (p *printer) list(open, close string, n int, element func(int) string) string {
+	if n == 0 {
+		return open + close
+	}
+	elements := make([]string, n)
+	for i := range elements {
+		elements[i] = element(i)
+	}
+	flat := open + strings.Join(elements, ", ") + close
+	if !strings.Contains(flat, "\n") && len(flat)+len(p.options.Indent)*p.level <= p.options.LineWidth {
+		return flat
+	}
+
+	p.level++
+	indent := strings.Repeat(p.options.Indent, p.level)
+	for i := range elements {
+		elements[i] = indent + element(i)
+	}
+	p.level--
+	return open + "\n" + strings.Join(elements, ",\n") + "\n" + strings.Repeat(p.options.Indent, p.level) + close
+}
+
+func (p *printer) literal(literal *Literal) string {
+	switch value := literal.value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(value)
+	case string:
+		return quote(value)
+	case time.Duration:
+		if literal.raw != "" {
+			return literal.raw
+		}
+		return value.String()
+	}
+	if isNumber(literal.value) {
+		if literal.raw != "" {
+			return literal.raw
+		}
+		number, _ := toFloat64(literal.value)
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	panic(fmt.Errorf("cannot print literal of type %T", literal.value))
+}
+
+func canonicalOperator(token Token) string {
+	if token.tokenType == NOT_IN {
+		return "not in"
+	}
+	return token.lexeme
+}
+
+// isText reports whether literal holds the raw text between two actions of
+// a template. Only text has a value identical to its source, since string
+// literals are quoted.
+func isText(literal *Literal) bool {
+	value, ok := literal.value.(string)
+	return ok && value == literal.raw
+}
+
+func isIdentifier(str string) bool {
+	if str == "" {
+		return false
+	}
+	if _, ok := keywords[str]; ok {
+		return false
+	}
+	for i, r := range str {
+		if !isAlphaNumeric(r) || (i == 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// quote returns str as a double quoted string literal, escaping it so that
+// unquote gives back str.
+func quote(str string) string {
+	builder := strings.Builder{}
+	builder.Grow(len(str) + 2)
+	builder.WriteByte('"')
+	for i, r := range str {
+		if r == utf8.RuneError {
+			if _, w := utf8.DecodeRuneInString(str[i:]); w == 1 {
+				builder.WriteString(`\u{FFFD}`)
+				continue
+			}
+		}
+		switch r {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\b':
+			builder.WriteString(`\b`)
+		case '\f':
+			builder.WriteString(`\f`)
+		case '\v':
+			builder.WriteString(`\v`)
+		default:
+			if unicode.IsPrint(r) {
+				builder.WriteRune(r)
+			} else {
+				builder.WriteString(fmt.Sprintf(`\u{%X}`, r))
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
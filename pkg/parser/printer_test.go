@@ -0,0 +1,135 @@
+package parser_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// tree renders the structure of expr, ignoring positions and parentheses.
+func tree(expr parser.Expr) string {
+	if grouping, ok := expr.(*parser.Grouping); ok {
+		return tree(grouping.Expression())
+	}
+	label := describe(expr)
+	if literal, ok := expr.(*parser.Literal); ok {
+		label = fmt.Sprintf("Literal %T %q", literal.Value(), fmt.Sprint(literal.Value()))
+	}
+	children := parser.Children(expr)
+	if len(children) == 0 {
+		return label
+	}
+	parts := make([]string, len(children))
+	for i, child := range children {
+		parts[i] = tree(child)
+	}
+	return "(" + label + " " + strings.Join(parts, " ") + ")"
+}
+
+func TestPrint(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1+2*3", "1 + 2 * 3"},
+		{"(1+2)*3", "(1 + 2) * 3"},
+		{"((a))", "a"},
+		{"1-(2-3)", "1 - (2 - 3)"},
+		{"(1-2)-3", "1 - 2 - 3"},
+		{"2**(3**2)", "2 ** 3 ** 2"},
+		{"(2**3)**2", "(2 ** 3) ** 2"},
+		{"(-2)**2", "(-2) ** 2"},
+		{"-(2**2)", "-2 ** 2"},
+		{"!(a&&b)", "!(a && b)"},
+		{"not (a and b)", "not (a and b)"},
+		{"not a == b", "not a == b"},
+		{"(not a) == b", "(not a) == b"},
+		{"a not   in b", "a not in b"},
+		{"(a < b) < c", "(a < b) < c"},
+		{"a == (b == c)", "a == (b == c)"},
+		{"a ? b : c ? d : e", "a ? b : c ? d : e"},
+		{"(a ? b : c) ? d : e", "(a ? b : c) ? d : e"},
+		{"(a ? b : c) ?? d", "(a ? b : c) ?? d"},
+		{"a ?? (b ? c : d) ?? e", "a ?? (b ? c : d) ?? e"},
+		{"a ?? (b ?: c)", "a ?? (b ?: c)"},
+		{"a?.b?.[c]?.(d)", "a?.b?.[c]?.(d)"},
+		{"(a+b).c", "(a + b).c"},
+		{"(1).toString", "(1).toString"},
+		{"f( a,b )[ 0 ]", "f(a, b)[0]"},
+		{"1.50 + 0.5", "1.50 + 0.5"},
+		{`'it\'s' + "\x41\u{1F600}\t"`, "\"it's\" + \"A😀\\t\""},
+		{`"say \"hi\"\\"`, `"say \"hi\"\\"`},
+		{"[ ]", "[]"},
+		{"{ }", "{}"},
+		{`{a:1, "b c":2, [k]:3, 'if':4, [5]:6}`, `{a: 1, "b c": 2, [k]: 3, if: 4, [5]: 6}`},
+		{"{'nil': true}", `{"nil": true}`},
+		{"(x)=>x*2", "x => x * 2"},
+		{"( a,b ) => a+b", "(a, b) => a + b"},
+		{"()=>1", "() => 1"},
+		{"items.map(x => x.price)", "items.map(x => x.price)"},
+		{"(x => x)(1)", "(x => x)(1)"},
+		{"(x => x) ?? y", "(x => x) ?? y"},
+		{"a ? x => x : y", "a ? x => x : y"},
+		{"now()-1d>=t", "now() - 1d >= t"},
+		{"2h30m.minutes", "2h30m.minutes"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			ast := parser.ParseExpression(test.input)
+			printed, err := parser.Print(ast)
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, printed)
+
+			reparsed := parser.ParseExpression(printed)
+			assert.Equal(t, tree(ast), tree(reparsed))
+			again, err := parser.Print(reparsed)
+			assert.Nil(t, err)
+			assert.Equal(t, printed, again)
+		})
+	}
+}
+
+func TestPrintTemplate(t *testing.T) {
+	ast := parser.NewParser("Hello @{{user.name}}, you have @{{  count+1}} new {messages}").Parse()
+	printed, err := parser.Print(ast)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello @{{ user.name }}, you have @{{ count + 1 }} new {messages}", printed)
+	assert.Equal(t, tree(ast), tree(parser.NewParser(printed).Parse()))
+
+	printed, err = parser.PrintWithOptions(
+		parser.NewParserWithOptions("<% 'a' %>b", parser.ParserOptions{LeftDelim: "<%", RightDelim: "%>"}).Parse(),
+		parser.PrinterOptions{LeftDelim: "<%", RightDelim: "%>"},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, `<% "a" %>b`, printed)
+}
+
+func TestPrintLongLiterals(t *testing.T) {
+	ast := parser.ParseExpression(`{name: "a rather long name", tags: ["one", "two", "three"], nested: {values: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20]}}`)
+	printed, err := parser.PrintWithOptions(ast, parser.PrinterOptions{Indent: "  "})
+	assert.Nil(t, err)
+	assert.Equal(t, `{
+  name: "a rather long name",
+  tags: ["one", "two", "three"],
+  nested: {
+    values: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20]
+  }
+}`, printed)
+	assert.Equal(t, tree(ast), tree(parser.ParseExpression(printed)))
+
+	printed, err = parser.PrintWithOptions(ast, parser.PrinterOptions{LineWidth: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, tree(ast), tree(parser.ParseExpression(printed)))
+	again, _ := parser.PrintWithOptions(parser.ParseExpression(printed), parser.PrinterOptions{LineWidth: 30})
+	assert.Equal(t, printed, again)
+}
+
+func TestPrintParseError(t *testing.T) {
+	ast, errs := parser.NewParser("@{{ 1 + }}").ParseAll()
+	assert.NotNil(t, errs.Err())
+	_, err := parser.Print(ast)
+	assert.ErrorContains(t, err, "cannot print an expression that failed to parse")
+}
@@ -0,0 +1,34 @@
+package parser
+
+type (
+	// Scope holds the variables of an evaluation. Names a scope does not
+	// define are looked up in its parent, so request data can be layered
+	// over shared values without copying them. The members of the
+	// Evaluator are looked up last.
+	Scope struct {
+		parent *Scope
+		vars   map[string]interface{}
+	}
+)
+
+// NewScope returns a scope holding vars, with parent as its enclosing
+// scope. parent can be nil.
+func NewScope(parent *Scope, vars map[string]interface{}) *Scope {
+	return &Scope{parent: parent, vars: vars}
+}
+
+// Parent returns the enclosing scope, or nil if s is a root scope.
+func (s *Scope) Parent() *Scope {
+	return s.parent
+}
+
+// Lookup returns the value of the variable called name in the nearest scope
+// that defines it.
+func (s *Scope) Lookup(name string) (interface{}, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, ok := scope.vars[name]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
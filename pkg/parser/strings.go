@@ -0,0 +1,226 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// stringMethods are the methods of strings. Lengths and indexes count
+// characters, not bytes.
+var stringMethods map[string]method
+
+func init() {
+	stringMethods = map[string]method{
+		"upper":      {0, 0, stringFunc(strings.ToUpper)},
+		"lower":      {0, 0, stringFunc(strings.ToLower)},
+		"trim":       {0, 1, stringTrim(strings.TrimSpace, strings.Trim)},
+		"trimStart":  {0, 1, stringTrim(func(s string) string { return strings.TrimLeft(s, " \t\r\n\v\f") }, strings.TrimLeft)},
+		"trimEnd":    {0, 1, stringTrim(func(s string) string { return strings.TrimRight(s, " \t\r\n\v\f") }, strings.TrimRight)},
+		"split":      {1, 2, stringSplit},
+		"replace":    {2, 2, stringReplace(1)},
+		"replaceAll": {2, 2, stringReplace(-1)},
+		"startsWith": {1, 1, stringPredicate(strings.HasPrefix)},
+		"endsWith":   {1, 1, stringPredicate(strings.HasSuffix)},
+		"contains":   {1, 1, stringPredicate(strings.Contains)},
+		"padStart":   {1, 2, stringPad(true)},
+		"padEnd":     {1, 2, stringPad(false)},
+		"repeat":     {1, 1, stringRepeat},
+		"substring":  {1, 2, stringSubstring},
+		"charAt":     {1, 1, stringCharAt},
+		"indexOf":    {1, 1, stringIndexOf},
+	}
+}
+
+// stringArgument returns arg as a string. what names the argument in the
+// error if it is not one.
+func stringArgument(arg interface{}, what string) (string, error) {
+	str, ok := arg.(string)
+	if !ok {
+		return "", fmt.Errorf("%s '%v' is not a string", what, arg)
+	}
+	return str, nil
+}
+
+// intArgument returns arg as an int. what names the argument in the error
+// if it is not one.
+func intArgument(arg interface{}, what string) (int, error) {
+	n, ok := toInt(arg)
+	if !ok {
+		return 0, fmt.Errorf("%s '%v' is not an integer", what, arg)
+	}
+	return n, nil
+}
+
+func stringFunc(fn func(string) string) builtin {
+	return func(_ *Evaluator, _ context.Context, receiver reflect.Value, _ []interface{}) (interface{}, error) {
+		return fn(receiver.String()), nil
+	}
+}
+
+// stringTrim trims whitespace with space, or the characters passed to the
+// method with cutset.
+func stringTrim(space func(string) string, cutset func(string, string) string) builtin {
+	return func(_ *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return space(receiver.String()), nil
+		}
+		chars, err := stringArgument(args[0], "cutset")
+		if err != nil {
+			return nil, err
+		}
+		return cutset(receiver.String(), chars), nil
+	}
+}
+
+func stringPredicate(fn func(string, string) bool) builtin {
+	return func(_ *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+		str, err := stringArgument(args[0], "argument")
+		if err != nil {
+			return nil, err
+		}
+		return fn(receiver.String(), str), nil
+	}
+}
+
+// stringSplit splits the string around a separator, into characters if it
+// is empty, returning at most limit parts if a limit is given.
+func stringSplit(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	separator, err := stringArgument(args[0], "separator")
+	if err != nil {
+		return nil, err
+	}
+	limit := -1
+	if len(args) == 2 {
+		if limit, err = intArgument(args[1], "limit"); err != nil {
+			return nil, err
+		}
+	}
+	parts := strings.Split(receiver.String(), separator)
+	if limit >= 0 && limit < len(parts) {
+		parts = parts[:limit]
+	}
+	split := make([]interface{}, len(parts))
+	for i, part := range parts {
+		split[i] = part
+	}
+	return split, nil
+}
+
+// stringReplace replaces the first n occurrences of a string, or all of
+// them if n is negative.
+func stringReplace(n int) builtin {
+	return func(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+		old, err := stringArgument(args[0], "argument")
+		if err != nil {
+			return nil, err
+		}
+		replacement, err := stringArgument(args[1], "replacement")
+		if err != nil {
+			return nil, err
+		}
+		replaced := strings.Replace(receiver.String(), old, replacement, n)
+		return replaced, e.limits.checkString(replaced)
+	}
+}
+
+// stringPad pads the string with spaces, or with the string passed to the
+// method, until it is as long as the length passed to it.
+func stringPad(start bool) builtin {
+	return func(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+		length, err := intArgument(args[0], "length")
+		if err != nil {
+			return nil, err
+		}
+		pad := " "
+		if len(args) == 2 {
+			if pad, err = stringArgument(args[1], "padding"); err != nil {
+				return nil, err
+			}
+		}
+		str := receiver.String()
+		missing := length - utf8.RuneCountInString(str)
+		if missing <= 0 || pad == "" {
+			return str, nil
+		}
+		if e.limits.MaxStringLength > 0 && missing > e.limits.MaxStringLength {
+			return nil, &StringLimitError{Limit: e.limits.MaxStringLength, Length: len(str) + missing}
+		}
+		runes := []rune(strings.Repeat(pad, missing/utf8.RuneCountInString(pad)+1))[:missing]
+		if start {
+			str = string(runes) + str
+		} else {
+			str += string(runes)
+		}
+		return str, e.limits.checkString(str)
+	}
+}
+
+func stringRepeat(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	count, err := intArgument(args[0], "count")
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("cannot repeat a string %d times", count)
+	}
+	str := receiver.String()
+	if e.limits.MaxStringLength > 0 && len(str) > 0 && count > e.limits.MaxStringLength/len(str) {
+		return nil, &StringLimitError{Limit: e.limits.MaxStringLength, Length: len(str) * count}
+	}
+	return strings.Repeat(str, count), nil
+}
+
+// stringSubstring returns the characters from start up to end, excluded.
+// As in javascript, negative indexes are 0 and the indexes are swapped if
+// start is after end.
+func stringSubstring(_ *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	runes := []rune(receiver.String())
+	bounds := []int{0, len(runes)}
+	for i, arg := range args {
+		bound, err := intArgument(arg, "index")
+		if err != nil {
+			return nil, err
+		}
+		if bound < 0 {
+			bound = 0
+		} else if bound > len(runes) {
+			bound = len(runes)
+		}
+		bounds[i] = bound
+	}
+	if bounds[0] > bounds[1] {
+		bounds[0], bounds[1] = bounds[1], bounds[0]
+	}
+	return string(runes[bounds[0]:bounds[1]]), nil
+}
+
+// stringCharAt returns the character at an index, or "" if there is none.
+func stringCharAt(_ *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	index, err := intArgument(args[0], "index")
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(receiver.String())
+	if index < 0 || index >= len(runes) {
+		return "", nil
+	}
+	return string(runes[index]), nil
+}
+
+// stringIndexOf returns the index in characters of the first occurrence of
+// a string, or -1.
+func stringIndexOf(e *Evaluator, _ context.Context, receiver reflect.Value, args []interface{}) (interface{}, error) {
+	sub, err := stringArgument(args[0], "argument")
+	if err != nil {
+		return nil, err
+	}
+	str := receiver.String()
+	index := strings.Index(str, sub)
+	if index < 0 {
+		return e.integer(-1), nil
+	}
+	return e.integer(utf8.RuneCountInString(str[:index])), nil
+}
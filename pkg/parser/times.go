@@ -0,0 +1,256 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationUnit is a unit of duration literals.
+type durationUnit struct {
+	name string
+	size time.Duration
+}
+
+// durationUnits are the units of duration literals, longest first so that
+// "ms" is not read as minutes.
+var durationUnits = []durationUnit{
+	{"ns", time.Nanosecond},
+	{"us", time.Microsecond},
+	{"µs", time.Microsecond},
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+}
+
+// layouts are the names that date and format accept instead of a layout.
+var layouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC822":      time.RFC822,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    "2006-01-02 15:04:05",
+	"DateOnly":    "2006-01-02",
+	"TimeOnly":    "15:04:05",
+}
+
+// SetClock sets the clock that `now()` reads, time.Now by default. Tests
+// can pass a fixed clock to render the same output on every run.
+func (i *Evaluator) SetClock(clock func() time.Time) {
+	i.clock = clock
+}
+
+// builtins are the built-in functions by name. Members and variables of the
+// same name take precedence.
+var builtins = map[string]func(e *Evaluator) interface{}{
+	"now":      func(e *Evaluator) interface{} { return e.Now },
+	"date":     func(*Evaluator) interface{} { return parseDate },
+	"format":   func(*Evaluator) interface{} { return formatDate },
+	"timezone": func(*Evaluator) interface{} { return timezone },
+}
+
+// builtin returns the built-in function called name.
+func (e *Evaluator) builtin(name string) (interface{}, bool) {
+	if fn, ok := builtins[name]; ok {
+		return fn(e), true
+	}
+	return nil, false
+}
+
+// Now returns the time of the clock set by SetClock.
+func (e *Evaluator) Now() time.Time {
+	if e.clock != nil {
+		return e.clock()
+	}
+	return time.Now()
+}
+
+// parseDate parses value with a Go layout or the name of one, such as
+// "RFC3339".
+func parseDate(layout, value string) (time.Time, error) {
+	if named, ok := layouts[layout]; ok {
+		layout = named
+	}
+	return time.Parse(layout, value)
+}
+
+// formatDate formats t with a Go layout or the name of one.
+func formatDate(t time.Time, layout string) string {
+	if named, ok := layouts[layout]; ok {
+		layout = named
+	}
+	return t.Format(layout)
+}
+
+// timezone returns t in the IANA time zone called name, such as
+// "Europe/Paris", "UTC" or "Local".
+func timezone(t time.Time, name string) (time.Time, error) {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(location), nil
+}
+
+// parseDuration parses the lexeme of a duration literal, such as "2h30m" or
+// "1.5d".
+func parseDuration(lexeme string) (time.Duration, error) {
+	var total float64
+	rest := lexeme
+	for rest != "" {
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != '_'
+		})
+		if end <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", lexeme)
+		}
+		value, err := strconv.ParseFloat(strings.ReplaceAll(rest[:end], "_", ""), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", lexeme)
+		}
+		unit, ok := unitPrefix(rest[end:])
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q", lexeme)
+		}
+		total += value * float64(unit.size)
+		rest = rest[end+len(unit.name):]
+	}
+	if total >= math.MaxInt64 {
+		return 0, fmt.Errorf("duration %q is too long", lexeme)
+	}
+	return time.Duration(total), nil
+}
+
+// unitPrefix returns the unit str starts with.
+func unitPrefix(str string) (durationUnit, bool) {
+	for _, unit := range durationUnits {
+		if strings.HasPrefix(str, unit.name) {
+			return unit, true
+		}
+	}
+	return durationUnit{}, false
+}
+
+// temporal applies an arithmetic operator to times and durations:
+//
+//   - time - time is the duration between them;
+//   - time ± duration and duration + time are times;
+//   - duration ± duration is a duration;
+//   - duration * number, number * duration and duration / number are
+//     durations;
+//   - duration / duration is a number.
+//
+// ok is false if neither operand is a time or a duration.
+func (e *Evaluator) temporal(operator TokenType, left, right interface{}) (value interface{}, ok bool, err error) {
+	switch l := left.(type) {
+	case time.Time:
+		switch r := right.(type) {
+		case time.Time:
+			if operator == MINUS {
+				return l.Sub(r), true, nil
+			}
+		case time.Duration:
+			switch operator {
+			case PLUS:
+				return l.Add(r), true, nil
+			case MINUS:
+				return l.Add(-r), true, nil
+			}
+		}
+	case time.Duration:
+		switch r := right.(type) {
+		case time.Time:
+			if operator == PLUS {
+				return r.Add(l), true, nil
+			}
+		case time.Duration:
+			switch operator {
+			case PLUS:
+				sum := l + r
+				if (sum > l) != (r > 0) {
+					return nil, true, fmt.Errorf("duration overflow: %s + %s", l, r)
+				}
+				return sum, true, nil
+			case MINUS:
+				difference := l - r
+				if (difference < l) != (r > 0) {
+					return nil, true, fmt.Errorf("duration overflow: %s - %s", l, r)
+				}
+				return difference, true, nil
+			case SLASH:
+				if r == 0 {
+					return nil, true, fmt.Errorf("division by zero: %s / %s", l, r)
+				}
+				value, _, err := e.arithmetic(SLASH, int64(l), int64(r))
+				return value, true, err
+			}
+		default:
+			if isNumber(right) && (operator == STAR || operator == SLASH) {
+				value, err := scaleDuration(operator, l, right)
+				return value, true, err
+			}
+		}
+	default:
+		if r, isDuration := right.(time.Duration); isDuration && isNumber(left) && operator == STAR {
+			value, err := scaleDuration(STAR, r, left)
+			return value, true, err
+		}
+	}
+	_, leftTemporal := left.(time.Time)
+	_, rightTemporal := right.(time.Time)
+	_, leftDuration := left.(time.Duration)
+	_, rightDuration := right.(time.Duration)
+	if leftTemporal || rightTemporal || leftDuration || rightDuration {
+		return nil, true, fmt.Errorf("cannot apply %s to %T and %T", operatorSymbol(operator), left, right)
+	}
+	return nil, false, nil
+}
+
+// scaleDuration multiplies or divides d by a number.
+func scaleDuration(operator TokenType, d time.Duration, number interface{}) (time.Duration, error) {
+	n, _ := toFloat64(number)
+	scaled := float64(d) * n
+	if operator == SLASH {
+		if n == 0 {
+			return 0, fmt.Errorf("division by zero: %s / %v", d, number)
+		}
+		scaled = float64(d) / n
+	}
+	if math.IsNaN(scaled) || scaled >= math.MaxInt64 || scaled < math.MinInt64 {
+		return 0, fmt.Errorf("duration overflow: %s %s %v", d, operatorSymbol(operator), number)
+	}
+	return time.Duration(math.Round(scaled)), nil
+}
+
+// compareTimes compares two times or two durations. ok is false for other
+// operands.
+func compareTimes(left, right interface{}) (cmp int, ok bool) {
+	switch l := left.(type) {
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			switch {
+			case l.Before(r):
+				return -1, true
+			case l.After(r):
+				return 1, true
+			}
+			return 0, true
+		}
+	case time.Duration:
+		if r, ok := right.(time.Duration); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
@@ -5,30 +5,36 @@ import (
 )
 
 type (
+	// Visitor evaluates expressions. Every Expr calls the method of the
+	// Visitor that matches its type from Accept.
 	Visitor interface {
-		visitBinaryExpr(context.Context, *Binary) EvaluationResult
-		visitGroupingExpr(context.Context, *Grouping) EvaluationResult
-		visitLiteralExpr(context.Context, *Literal) EvaluationResult
-		visitUnaryExpr(context.Context, *Unary) EvaluationResult
-
-		visitTemplateExpr(context.Context, *Template) EvaluationResult
-		visitTernaryExpr(context.Context, *Ternary) EvaluationResult
-		visitGetExpr(context.Context, *Get) EvaluationResult
-		visitOptionalExpr(context.Context, *Optional) EvaluationResult
-		visitIndexExpr(context.Context, *Index) EvaluationResult
-		visitVariableExpr(context.Context, *Variable) EvaluationResult
-		visitCallExpr(context.Context, *Call) EvaluationResult
-		visitArrayExpr(context.Context, *Array) EvaluationResult
-		visitMapExpr(context.Context, *Map) EvaluationResult
-		visitMapEntryExpr(context.Context, *MapEntry) EvaluationResult
-
-		visitParseErrorExpr(context.Context, *ParseError) EvaluationResult
+		VisitBinaryExpr(context.Context, *Binary) EvaluationResult
+		VisitGroupingExpr(context.Context, *Grouping) EvaluationResult
+		VisitLiteralExpr(context.Context, *Literal) EvaluationResult
+		VisitUnaryExpr(context.Context, *Unary) EvaluationResult
+
+		VisitTemplateExpr(context.Context, *Template) EvaluationResult
+		VisitTernaryExpr(context.Context, *Ternary) EvaluationResult
+		VisitGetExpr(context.Context, *Get) EvaluationResult
+		VisitOptionalExpr(context.Context, *Optional) EvaluationResult
+		VisitIndexExpr(context.Context, *Index) EvaluationResult
+		VisitVariableExpr(context.Context, *Variable) EvaluationResult
+		VisitCallExpr(context.Context, *Call) EvaluationResult
+		VisitArrayExpr(context.Context, *Array) EvaluationResult
+		VisitMapExpr(context.Context, *Map) EvaluationResult
+		VisitMapEntryExpr(context.Context, *MapEntry) EvaluationResult
+		VisitComparisonExpr(context.Context, *Comparison) EvaluationResult
+		VisitLambdaExpr(context.Context, *Lambda) EvaluationResult
+
+		VisitParseErrorExpr(context.Context, *ParseError) EvaluationResult
 	}
 	Interpreter interface {
 		Evaluate(expr Expr) EvaluationResult
 	}
 	Expr interface {
 		Accept(context.Context, Visitor) EvaluationResult
+		// Span returns the part of the source the expression was parsed from.
+		Span() Span
 	}
 
 	EvaluationResult interface {
@@ -50,6 +56,10 @@ var (
 	_ EvaluationResult = &result{}
 )
 
+func newResult(value interface{}, err error) EvaluationResult {
+	return &result{value: value, err: err}
+}
+
 func (o *optionalEvaluationResult) IsAbsent() bool {
 	return o.absent
 }
@@ -0,0 +1,82 @@
+package parser
+
+import "errors"
+
+// SkipChildren can be returned by the function passed to Walk to skip the
+// children of the current expression.
+var SkipChildren = errors.New("skip children")
+
+// Children returns the direct sub-expressions of expr in the order they
+// appear in the source.
+func Children(expr Expr) []Expr {
+	switch e := expr.(type) {
+	case *Binary:
+		return []Expr{e.left, e.right}
+	case *Grouping:
+		return []Expr{e.expression}
+	case *Unary:
+		return []Expr{e.right}
+	case *Template:
+		return e.expressions
+	case *Ternary:
+		return []Expr{e.condition, e.trueExpr, e.falseExpr}
+	case *Get:
+		return []Expr{e.object}
+	case *Optional:
+		return []Expr{e.left}
+	case *Call:
+		return append([]Expr{e.callee}, e.arguments...)
+	case *Index:
+		return []Expr{e.object, e.index}
+	case *Array:
+		return e.values
+	case *Map:
+		children := make([]Expr, len(e.entries))
+		for i, entry := range e.entries {
+			children[i] = entry
+		}
+		return children
+	case *MapEntry:
+		return []Expr{e.key, e.value}
+	case *Comparison:
+		return e.operands
+	case *Lambda:
+		return []Expr{e.body}
+	}
+	return nil
+}
+
+// Walk calls fn for expr and then for each of its descendants, depth-first
+// and in source order. If fn returns SkipChildren, the children of the
+// current expression are not visited. Any other error stops the walk and is
+// returned by Walk.
+func Walk(expr Expr, fn func(Expr) error) error {
+	if expr == nil {
+		return nil
+	}
+	if err := fn(expr); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+	for _, child := range Children(expr) {
+		if err := Walk(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Inspect traverses the AST like ast.Inspect does in go/ast: it calls f(expr)
+// and, if f returns true, inspects each child of expr followed by a call of
+// f(nil).
+func Inspect(expr Expr, f func(Expr) bool) {
+	if expr == nil || !f(expr) {
+		return
+	}
+	for _, child := range Children(expr) {
+		Inspect(child, f)
+	}
+	f(nil)
+}
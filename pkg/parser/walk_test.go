@@ -0,0 +1,115 @@
+package parser_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func describe(expr parser.Expr) string {
+	switch e := expr.(type) {
+	case *parser.Binary:
+		return "Binary " + e.Operator().Lexeme()
+	case *parser.Unary:
+		return "Unary " + e.Operator().Lexeme()
+	case *parser.Variable:
+		return "Variable " + e.Name().Lexeme()
+	case *parser.Get:
+		return "Get " + e.Name().Lexeme()
+	case *parser.Literal:
+		return fmt.Sprintf("Literal %v", e.Value())
+	case *parser.Call:
+		return fmt.Sprintf("Call %d", len(e.Arguments()))
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", expr), "*parser.")
+}
+
+func TestWalk(t *testing.T) {
+	ast := parser.NewParser(`Hi @{{ user.name ?? greet(-1, [a], {k: b}) }}`).Parse()
+	visited := make([]string, 0)
+	err := parser.Walk(ast, func(expr parser.Expr) error {
+		visited = append(visited, describe(expr))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"Template",
+		"Literal Hi ",
+		"Binary ??",
+		"Get name",
+		"Variable user",
+		"Call 3",
+		"Variable greet",
+		"Unary -",
+		"Literal 1",
+		"Array",
+		"Variable a",
+		"Map",
+		"MapEntry",
+		"Literal k",
+		"Variable b",
+	}, visited)
+
+	visited = visited[:0]
+	err = parser.Walk(ast, func(expr parser.Expr) error {
+		visited = append(visited, describe(expr))
+		if _, ok := expr.(*parser.Call); ok {
+			return parser.SkipChildren
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Template", "Literal Hi ", "Binary ??", "Get name", "Variable user", "Call 3"}, visited)
+
+	stop := errors.New("stop")
+	err = parser.Walk(ast, func(expr parser.Expr) error {
+		if _, ok := expr.(*parser.Variable); ok {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+}
+
+func TestInspect(t *testing.T) {
+	ast := parser.ParseExpression(`a.b(c) + (d ? 1 : 2)`)
+	depth, maxDepth := 0, 0
+	variables := make([]string, 0)
+	parser.Inspect(ast, func(expr parser.Expr) bool {
+		if expr == nil {
+			depth--
+			return false
+		}
+		if v, ok := expr.(*parser.Variable); ok {
+			variables = append(variables, v.Name().Lexeme())
+		}
+		if _, ok := expr.(*parser.Ternary); ok {
+			return false
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		return true
+	})
+	assert.Equal(t, 0, depth)
+	assert.Equal(t, 4, maxDepth)
+	assert.Equal(t, []string{"a", "c"}, variables)
+}
+
+func TestTokenAccessors(t *testing.T) {
+	ast := parser.ParseExpression("1 +\n  foo")
+	binary := ast.(*parser.Binary)
+	variable := binary.Right().(*parser.Variable)
+	assert.Equal(t, parser.PLUS, binary.Operator().Type())
+	assert.Equal(t, "PLUS", binary.Operator().Type().String())
+	assert.Equal(t, "foo", variable.Name().Lexeme())
+	assert.Equal(t, 6, variable.Name().Offset())
+	assert.Equal(t, 2, variable.Name().Line())
+	assert.Equal(t, parser.Position{Offset: 6, Line: 2, Column: 3}, variable.Span().Start)
+	assert.Equal(t, "1", binary.Left().(*parser.Literal).Raw())
+}
@@ -0,0 +1,124 @@
+package stdlib
+
+import (
+	"fmt"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// Collections returns the `collections` module. Arrays and maps also have
+// methods, such as `items.map(...)`, for the most common operations.
+func Collections() parser.Module {
+	return parser.Module{
+		Name: "collections",
+		Doc:  "Operations that combine or build arrays and maps.",
+		Functions: []parser.Function{
+			{
+				Name:   "concat",
+				Doc:    "Joins arrays into a single array.",
+				Params: []parser.Param{variadic("arrays", "array")},
+				Result: "array",
+				Func:   concat,
+			},
+			{
+				Name:   "zip",
+				Doc:    "Pairs the elements of arrays at the same index, up to the length of the shortest.",
+				Params: []parser.Param{variadic("arrays", "array")},
+				Result: "array",
+				Func:   zip,
+			},
+			{
+				Name:   "chunk",
+				Doc:    "Splits an array into arrays of a size, the last of which can be shorter.",
+				Params: []parser.Param{param("array", "array"), param("size", "number")},
+				Result: "array",
+				Func:   chunk,
+			},
+			{
+				Name:   "fromEntries",
+				Doc:    "Builds a map from an array of [key, value] pairs.",
+				Params: []parser.Param{param("entries", "array")},
+				Result: "map",
+				Func:   fromEntries,
+			},
+		},
+	}
+}
+
+func concat(arrays ...interface{}) ([]interface{}, error) {
+	joined := make([]interface{}, 0)
+	for _, array := range arrays {
+		items, err := elements(array)
+		if err != nil {
+			return nil, err
+		}
+		joined = append(joined, items...)
+	}
+	return joined, nil
+}
+
+func zip(arrays ...interface{}) ([]interface{}, error) {
+	columns := make([][]interface{}, len(arrays))
+	length := -1
+	for i, array := range arrays {
+		items, err := elements(array)
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 || len(items) < length {
+			length = len(items)
+		}
+		columns[i] = items
+	}
+	if length < 0 {
+		length = 0
+	}
+	rows := make([]interface{}, length)
+	for i := range rows {
+		row := make([]interface{}, len(columns))
+		for j, column := range columns {
+			row[j] = column[i]
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+func chunk(array interface{}, size int) ([]interface{}, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("cannot split an array into chunks of size %d", size)
+	}
+	items, err := elements(array)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]interface{}, 0, len(items)/size+1)
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		chunks = append(chunks, items[start:end:end])
+	}
+	return chunks, nil
+}
+
+func fromEntries(entries interface{}) (map[string]interface{}, error) {
+	items, err := elements(entries)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		entry, err := elements(item)
+		if err != nil || len(entry) != 2 {
+			return nil, fmt.Errorf("entry %v is not a [key, value] pair", item)
+		}
+		key, ok := entry[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("key %v is not a string", entry[0])
+		}
+		m[key] = entry[1]
+	}
+	return m, nil
+}
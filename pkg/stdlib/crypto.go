@@ -0,0 +1,52 @@
+package stdlib
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// Crypto returns the `crypto` module. MD5 and SHA-1 are broken and only
+// meant for checksums and legacy identifiers.
+func Crypto() parser.Module {
+	digest := func(name, doc string, h func() hash.Hash) parser.Function {
+		return parser.Function{
+			Name:   name,
+			Doc:    doc,
+			Params: []parser.Param{param("s", "string")},
+			Result: "string",
+			Func: func(s string) string {
+				digest := h()
+				digest.Write([]byte(s))
+				return hex.EncodeToString(digest.Sum(nil))
+			},
+		}
+	}
+	return parser.Module{
+		Name: "crypto",
+		Doc:  "Hashes of strings, as lowercase hexadecimal.",
+		Functions: []parser.Function{
+			digest("md5", "Returns the MD5 hash of s.", md5.New),
+			digest("sha1", "Returns the SHA-1 hash of s.", sha1.New),
+			digest("sha256", "Returns the SHA-256 hash of s.", sha256.New),
+			digest("sha512", "Returns the SHA-512 hash of s.", sha512.New),
+			{
+				Name:   "hmac",
+				Doc:    "Returns the HMAC-SHA256 of a message with a key.",
+				Params: []parser.Param{param("key", "string"), param("message", "string")},
+				Result: "string",
+				Func: func(key, message string) string {
+					mac := hmac.New(sha256.New, []byte(key))
+					mac.Write([]byte(message))
+					return hex.EncodeToString(mac.Sum(nil))
+				},
+			},
+		},
+	}
+}
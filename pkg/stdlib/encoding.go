@@ -0,0 +1,71 @@
+package stdlib
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// Encoding returns the `encoding` module.
+func Encoding() parser.Module {
+	return parser.Module{
+		Name: "encoding",
+		Doc:  "Base64, hexadecimal and URL encodings of strings.",
+		Functions: []parser.Function{
+			{
+				Name:   "base64Encode",
+				Doc:    "Encodes s in standard base64 with padding.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func: func(s string) string {
+					return base64.StdEncoding.EncodeToString([]byte(s))
+				},
+			},
+			{
+				Name:   "base64Decode",
+				Doc:    "Decodes standard base64 with padding.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func: func(s string) (string, error) {
+					decoded, err := base64.StdEncoding.DecodeString(s)
+					return string(decoded), err
+				},
+			},
+			{
+				Name:   "hexEncode",
+				Doc:    "Encodes s in lowercase hexadecimal.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func: func(s string) string {
+					return hex.EncodeToString([]byte(s))
+				},
+			},
+			{
+				Name:   "hexDecode",
+				Doc:    "Decodes hexadecimal.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func: func(s string) (string, error) {
+					decoded, err := hex.DecodeString(s)
+					return string(decoded), err
+				},
+			},
+			{
+				Name:   "urlEncode",
+				Doc:    "Escapes s to be used in a URL query.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func:   url.QueryEscape,
+			},
+			{
+				Name:   "urlDecode",
+				Doc:    "Unescapes a URL query.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func:   url.QueryUnescape,
+			},
+		},
+	}
+}
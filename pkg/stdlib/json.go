@@ -0,0 +1,57 @@
+package stdlib
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// JSON returns the `json` module.
+func JSON() parser.Module {
+	return parser.Module{
+		Name: "json",
+		Doc:  "Encoding and decoding of JSON.",
+		Functions: []parser.Function{
+			{
+				Name:   "encode",
+				Doc:    "Encodes a value as compact JSON.",
+				Params: []parser.Param{param("value", "any")},
+				Result: "string",
+				Func: func(ctx context.Context, value interface{}) (string, error) {
+					value, err := export(ctx, value)
+					if err != nil {
+						return "", err
+					}
+					encoded, err := json.Marshal(value)
+					return string(encoded), err
+				},
+			},
+			{
+				Name:   "pretty",
+				Doc:    "Encodes a value as JSON indented with two spaces.",
+				Params: []parser.Param{param("value", "any")},
+				Result: "string",
+				Func: func(ctx context.Context, value interface{}) (string, error) {
+					value, err := export(ctx, value)
+					if err != nil {
+						return "", err
+					}
+					encoded, err := json.MarshalIndent(value, "", "  ")
+					return string(encoded), err
+				},
+			},
+			{
+				Name:   "decode",
+				Doc:    "Decodes JSON into maps, arrays, strings, numbers, booleans and nil.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "any",
+				Func: func(s string) (interface{}, error) {
+					var value interface{}
+					err := json.Unmarshal([]byte(s), &value)
+					return value, err
+				},
+			},
+		},
+	}
+}
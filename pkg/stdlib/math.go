@@ -0,0 +1,116 @@
+package stdlib
+
+import (
+	"errors"
+	"math"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// Math returns the `math` module.
+func Math() parser.Module {
+	unary := func(name, doc string, fn func(float64) float64) parser.Function {
+		return parser.Function{
+			Name:   name,
+			Doc:    doc,
+			Params: []parser.Param{param("x", "number")},
+			Result: "number",
+			Func:   fn,
+		}
+	}
+	return parser.Module{
+		Name: "math",
+		Doc:  "Arithmetic on numbers, which are converted to floats.",
+		Functions: []parser.Function{
+			unary("abs", "Returns the absolute value of x.", math.Abs),
+			unary("ceil", "Returns the least integer greater than or equal to x.", math.Ceil),
+			unary("floor", "Returns the greatest integer less than or equal to x.", math.Floor),
+			unary("trunc", "Returns the integer part of x.", math.Trunc),
+			unary("sqrt", "Returns the square root of x.", math.Sqrt),
+			unary("exp", "Returns e to the power of x.", math.Exp),
+			unary("log", "Returns the natural logarithm of x.", math.Log),
+			unary("log10", "Returns the decimal logarithm of x.", math.Log10),
+			unary("sign", "Returns -1, 0 or 1 depending on the sign of x.", sign),
+			{
+				Name:   "round",
+				Doc:    "Rounds x half away from zero, to a number of decimal places, 0 by default.",
+				Params: []parser.Param{param("x", "number"), optional("places", "number")},
+				Result: "number",
+				Func:   round,
+			},
+			{
+				Name:   "pow",
+				Doc:    "Returns x to the power of y.",
+				Params: []parser.Param{param("x", "number"), param("y", "number")},
+				Result: "number",
+				Func:   math.Pow,
+			},
+			{
+				Name:   "min",
+				Doc:    "Returns the smallest of its arguments.",
+				Params: []parser.Param{variadic("xs", "number")},
+				Result: "number",
+				Func:   extreme(-1),
+			},
+			{
+				Name:   "max",
+				Doc:    "Returns the largest of its arguments.",
+				Params: []parser.Param{variadic("xs", "number")},
+				Result: "number",
+				Func:   extreme(1),
+			},
+			{
+				Name:   "clamp",
+				Doc:    "Returns x limited to the range from low to high.",
+				Params: []parser.Param{param("x", "number"), param("low", "number"), param("high", "number")},
+				Result: "number",
+				Func:   clamp,
+			},
+		},
+	}
+}
+
+func sign(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return x
+}
+
+func round(x float64, places ...int) (float64, error) {
+	if len(places) > 1 {
+		return 0, tooManyArguments("round", len(places))
+	}
+	scale := 1.0
+	if len(places) == 1 {
+		scale = math.Pow(10, float64(places[0]))
+	}
+	return math.Round(x*scale) / scale, nil
+}
+
+// extreme returns a function that returns the smallest of its arguments if
+// direction is negative, or the largest if it is positive.
+func extreme(direction float64) func(xs ...float64) (float64, error) {
+	return func(xs ...float64) (float64, error) {
+		if len(xs) == 0 {
+			return 0, errors.New("no numbers to compare")
+		}
+		result := xs[0]
+		for _, x := range xs[1:] {
+			if (x-result)*direction > 0 {
+				result = x
+			}
+		}
+		return result, nil
+	}
+}
+
+func clamp(x, low, high float64) (float64, error) {
+	if low > high {
+		return 0, errors.New("the lower bound is greater than the upper bound")
+	}
+	return math.Max(low, math.Min(x, high)), nil
+}
@@ -0,0 +1,77 @@
+package stdlib
+
+import (
+	"regexp"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// Regex returns the `regex` module. Patterns use the RE2 syntax of the
+// regexp package, which matches in linear time.
+func Regex() parser.Module {
+	return parser.Module{
+		Name: "regex",
+		Doc:  "Regular expressions in RE2 syntax.",
+		Functions: []parser.Function{
+			{
+				Name:   "match",
+				Doc:    "Reports whether s contains a match of pattern.",
+				Params: []parser.Param{param("pattern", "string"), param("s", "string")},
+				Result: "bool",
+				Func:   regexp.MatchString,
+			},
+			{
+				Name:   "find",
+				Doc:    "Returns the first match of pattern in s, or \"\".",
+				Params: []parser.Param{param("pattern", "string"), param("s", "string")},
+				Result: "string",
+				Func: func(pattern, s string) (string, error) {
+					re, err := regexp.Compile(pattern)
+					if err != nil {
+						return "", err
+					}
+					return re.FindString(s), nil
+				},
+			},
+			{
+				Name:   "findAll",
+				Doc:    "Returns every match of pattern in s.",
+				Params: []parser.Param{param("pattern", "string"), param("s", "string")},
+				Result: "array",
+				Func: func(pattern, s string) ([]interface{}, error) {
+					re, err := regexp.Compile(pattern)
+					if err != nil {
+						return nil, err
+					}
+					return stringArray(re.FindAllString(s, -1)), nil
+				},
+			},
+			{
+				Name:   "replace",
+				Doc:    "Replaces every match of pattern in s. The replacement can refer to groups as $1 or ${name}.",
+				Params: []parser.Param{param("pattern", "string"), param("s", "string"), param("replacement", "string")},
+				Result: "string",
+				Func: func(pattern, s, replacement string) (string, error) {
+					re, err := regexp.Compile(pattern)
+					if err != nil {
+						return "", err
+					}
+					return re.ReplaceAllString(s, replacement), nil
+				},
+			},
+			{
+				Name:   "split",
+				Doc:    "Splits s around the matches of pattern.",
+				Params: []parser.Param{param("pattern", "string"), param("s", "string")},
+				Result: "array",
+				Func: func(pattern, s string) ([]interface{}, error) {
+					re, err := regexp.Compile(pattern)
+					if err != nil {
+						return nil, err
+					}
+					return stringArray(re.Split(s, -1)), nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,84 @@
+// Package stdlib provides modules of functions for expressions, to install
+// with parser.Evaluator.Use:
+//
+//	e := parser.NewInterpreter()
+//	e.Use(stdlib.Math(), stdlib.Strings(), stdlib.JSON())
+//
+//	// "@{{ math.round(price * 1.2, 2) }} @{{ json.encode(tags) }}"
+//
+// Every function is described by a parser.Function, whose Signature and Doc
+// can be listed by tooling such as editors.
+package stdlib
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// All returns every module, with the clock of the evaluator and a
+// cryptographically secure source of UUIDs.
+func All() []parser.Module {
+	return []parser.Module{
+		Math(),
+		Strings(),
+		Time(nil),
+		JSON(),
+		Regex(),
+		Encoding(),
+		Crypto(),
+		Collections(),
+		UUID(nil),
+	}
+}
+
+func param(name, typ string) parser.Param {
+	return parser.Param{Name: name, Type: typ}
+}
+
+func optional(name, typ string) parser.Param {
+	return parser.Param{Name: name, Type: typ, Optional: true}
+}
+
+func variadic(name, typ string) parser.Param {
+	return parser.Param{Name: name, Type: typ, Variadic: true}
+}
+
+// elements returns the elements of an array or slice of any type.
+func elements(value interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%v is not an array", value)
+	}
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+	return items, nil
+}
+
+// export converts value with Export of the evaluator calling the function,
+// so that it exposes no more than expressions can reach.
+func export(ctx context.Context, value interface{}) (interface{}, error) {
+	if e, ok := parser.EvaluatorFrom(ctx); ok {
+		return e.Export(value)
+	}
+	return value, nil
+}
+
+// stringArray converts a slice of strings to an array of expressions.
+func stringArray(values []string) []interface{} {
+	array := make([]interface{}, len(values))
+	for i, value := range values {
+		array[i] = value
+	}
+	return array
+}
+
+// tooManyArguments is returned by functions with an optional parameter,
+// which Go declares as variadic, when they get more than one.
+func tooManyArguments(name string, optional int) error {
+	return fmt.Errorf("function '%s' takes at most 1 optional argument, got %d", name, optional)
+}
@@ -0,0 +1,190 @@
+package stdlib_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+	"github.com/nonsocode/xpress/pkg/stdlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEvaluator() *parser.Evaluator {
+	clock := func() time.Time {
+		return time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	}
+	e := parser.NewInterpreter()
+	e.Use(stdlib.All()...)
+	e.Use(stdlib.Time(clock), stdlib.UUID(rand.New(rand.NewSource(1))))
+	e.AddMember("ids", []int{1, 2, 3, 4, 5})
+	return e
+}
+
+type successCase struct {
+	template string
+	expect   interface{}
+}
+
+type failureCase struct {
+	template string
+	msg      string
+}
+
+// runCases evaluates the templates of successes and failures with e.
+func runCases(t *testing.T, e *parser.Evaluator, successes []successCase, failures []failureCase) {
+	t.Helper()
+	for _, c := range successes {
+		t.Run(c.template, func(t *testing.T) {
+			res, err := e.Evaluate(context.Background(), parser.NewParser(c.template).Parse())
+			assert.Nil(t, err)
+			assert.Equal(t, c.expect, res)
+		})
+	}
+	for _, c := range failures {
+		t.Run(c.template, func(t *testing.T) {
+			_, err := e.Evaluate(context.Background(), parser.NewParser(c.template).Parse())
+			assert.ErrorContains(t, err, c.msg)
+		})
+	}
+}
+
+func TestModules(t *testing.T) {
+	successes := []successCase{
+		{"@{{ math.abs(-2) }} @{{ math.floor(2.7) }} @{{ math.ceil(2.1) }} @{{ math.sqrt(16) }}", "2 2 3 4"},
+		{"@{{ math.round(2.5) }} @{{ math.round(3.14159, 2) }} @{{ math.pow(2, 10) }}", "3 3.14 1024"},
+		{"@{{ math.min(3, 1, 2) }} @{{ math.max(3, 1, 2) }} @{{ math.clamp(12, 0, 10) }} @{{ math.sign(-4) }}", "1 3 10 -1"},
+		{"@{{ strings.concat('a', 'b', 'c') }} @{{ strings.format('%s=%.1f', 'x', 1.25) }}", "abc x=1.2"},
+		{"@{{ strings.title('ada lovelace') }} @{{ strings.reverse('Zürich') }}", "Ada Lovelace hcirüZ"},
+		{"@{{ strings.truncate('abcdefgh', 6) }} @{{ strings.truncate('abc', 6) }} @{{ strings.truncate('abcdefgh', 4, '~') }}", "abc... abc abc~"},
+		{"@{{ strings.count('banana', 'a') }}", 3},
+		{"@{{ strings.words(' a  b\tc ') }}", []interface{}{"a", "b", "c"}},
+		{"@{{ time.now() }}", time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)},
+		{"@{{ time.format(time.date(2024, 2, 29), '02/01/2006') }}", "29/02/2024"},
+		{"@{{ time.format(time.unix(86400), '2006-01-02') }}", "1970-01-02"},
+		{"@{{ time.since(time.parse('2006-01-02', '2024-03-01')) }}", 12 * time.Hour},
+		{"@{{ time.add(time.date(2024, 1, 1), time.duration('36h')).Day() }}", 2},
+		{"@{{ json.encode({a: [1, 'b', true, nil]}) }}", `{"a":[1,"b",true,null]}`},
+		{"@{{ json.decode('{\"a\": [1, 2]}').a[1] }}", float64(2)},
+		{"@{{ json.pretty([1]) }}", "[\n  1\n]"},
+		{"@{{ regex.match('^a.c$', 'abc') }} @{{ regex.find('[0-9]+', 'ab12cd34') }} @{{ regex.findAll('[0-9]+', 'ab12cd34') }}", "true 12 [12 34]"},
+		{"@{{ regex.replace('(\\\\w+)@(\\\\w+)', 'ada@home', '$2:$1') }} @{{ regex.split(',\\\\s*', 'a, b,c') }}", "home:ada [a b c]"},
+		{"@{{ encoding.base64Encode('hi?') }} @{{ encoding.base64Decode('aGk/') }}", "aGk/ hi?"},
+		{"@{{ encoding.hexEncode('hi') }} @{{ encoding.hexDecode('6869') }}", "6869 hi"},
+		{"@{{ encoding.urlEncode('a b&c') }} @{{ encoding.urlDecode('a+b%26c') }}", "a+b%26c a b&c"},
+		{"@{{ crypto.md5('') }}", "d41d8cd98f00b204e9800998ecf8427e"},
+		{"@{{ crypto.sha256('abc') }}", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"@{{ crypto.hmac('key', 'The quick brown fox jumps over the lazy dog') }}", "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		{"@{{ collections.concat(ids, [6]) }}", []interface{}{1, 2, 3, 4, 5, float64(6)}},
+		{"@{{ collections.zip(ids, ['a', 'b']) }}", []interface{}{[]interface{}{1, "a"}, []interface{}{2, "b"}}},
+		{"@{{ collections.chunk(ids, 2) }}", []interface{}{[]interface{}{1, 2}, []interface{}{3, 4}, []interface{}{5}}},
+		{"@{{ collections.fromEntries([['a', 1]]) }}", map[string]interface{}{"a": float64(1)}},
+		{"@{{ uuid.valid(uuid.new()) }} @{{ uuid.valid('nope') }}", "true false"},
+	}
+	failures := []failureCase{
+		{"@{{ math.max() }}", "no numbers to compare"},
+		{"@{{ math.round(1, 2, 3) }}", "function 'round' takes at most 1 optional argument, got 2"},
+		{"@{{ math.clamp(1, 2, 0) }}", "the lower bound is greater than the upper bound"},
+		{"@{{ json.decode('{') }}", "unexpected end of JSON input"},
+		{"@{{ regex.match('(', 'a') }}", "missing closing )"},
+		{"@{{ encoding.hexDecode('zz') }}", "invalid byte"},
+		{"@{{ collections.chunk(ids, 0) }}", "cannot split an array into chunks of size 0"},
+		{"@{{ collections.zip(ids, 1) }}", "1 is not an array"},
+		{"@{{ collections.fromEntries([['a']]) }}", "entry [a] is not a [key, value] pair"},
+		{"@{{ time.duration('soon') }}", "invalid duration"},
+	}
+	runCases(t, newEvaluator(), successes, failures)
+}
+
+func TestChunkSize(t *testing.T) {
+	e := newEvaluator()
+	e.SetNumberMode(parser.IntegerNumbers)
+	runCases(t, e, []successCase{
+		{"@{{ collections.chunk(ids, 9223372036854775807) }}", []interface{}{[]interface{}{1, 2, 3, 4, 5}}},
+	}, nil)
+}
+
+func TestLimits(t *testing.T) {
+	e := newEvaluator()
+	e.SetLimits(parser.Limits{MaxStringLength: 100})
+	e.SetNumberMode(parser.IntegerNumbers)
+	for _, template := range []string{
+		"@{{ strings.format('%200v', 1) }}",
+		"@{{ strings.format('%999999999d', 1) }}",
+		"@{{ strings.format('%.999999999f', 1.5) }}",
+		"@{{ strings.format('%60v%60v', 1, 2) }}",
+		"@{{ strings.format('%*d', 999999999, 1) }}",
+		"@{{ strings.format('%[2]*[1]d', 1, 999999999) }}",
+	} {
+		_, err := e.Evaluate(context.Background(), parser.NewParser(template).Parse())
+		var limit *parser.StringLimitError
+		assert.True(t, errors.As(err, &limit), template)
+	}
+	res, err := e.Evaluate(context.Background(), parser.NewParser("@{{ strings.format('%5v', 1) }}").Parse())
+	assert.Nil(t, err)
+	assert.Equal(t, "    1", res)
+}
+
+func TestEncodeAccess(t *testing.T) {
+	type Account struct {
+		Name     string
+		Password string `xpress:"-"`
+		Token    string
+	}
+	e := newEvaluator()
+	e.SetAccessPolicy(parser.NewAllowlist().AllowFields(reflect.TypeOf(Account{}), "Name"))
+	e.AddMember("account", &Account{Name: "Ada", Password: "secret", Token: "t0ken"})
+	runCases(t, e, []successCase{
+		{"@{{ json.encode(account) }} @{{ json.pretty([account]) }}", "{\"Name\":\"Ada\"} [\n  {\n    \"Name\": \"Ada\"\n  }\n]"},
+		{"@{{ strings.format('%+v', account) }}", "map[Name:Ada]"},
+	}, []failureCase{
+		{"@{{ json.encode(x => x) }}", "cannot export *parser.Closure"},
+	})
+}
+
+func TestEvaluatorClock(t *testing.T) {
+	e := parser.NewInterpreter()
+	e.Use(stdlib.All()...)
+	e.SetClock(func() time.Time {
+		return time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	})
+	runCases(t, e, []successCase{
+		{"@{{ now() == time.now() }} @{{ time.now().Year() }} @{{ time.since(now()) }}", "true 2024 0s"},
+	}, nil)
+}
+
+func TestUUIDSource(t *testing.T) {
+	generate := func() interface{} {
+		e := parser.NewInterpreter()
+		e.Use(stdlib.UUID(rand.New(rand.NewSource(42))))
+		res, err := e.Evaluate(context.Background(), parser.NewParser("@{{ uuid.new() }}").Parse())
+		assert.Nil(t, err)
+		return res
+	}
+	first := generate()
+	assert.Equal(t, first, generate())
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, first)
+}
+
+func TestSignatures(t *testing.T) {
+	signatures := map[string]string{}
+	for _, module := range stdlib.All() {
+		assert.NotEmpty(t, module.Doc, module.Name)
+		for _, fn := range module.Functions {
+			assert.NotEmpty(t, fn.Doc, fn.Name)
+			assert.NotNil(t, fn.Func, fn.Name)
+			signatures[module.Name+"."+fn.Name] = fn.Signature()
+		}
+	}
+	assert.Equal(t, "round(x number, places? number) number", signatures["math.round"])
+	assert.Equal(t, "format(format string, ...args any) string", signatures["strings.format"])
+	assert.Equal(t, "now() time", signatures["time.now"])
+}
+
+func TestUseRequiresName(t *testing.T) {
+	e := parser.NewInterpreter()
+	assert.EqualError(t, e.Use(parser.Module{}), "module has no name")
+}
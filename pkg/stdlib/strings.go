@@ -0,0 +1,218 @@
+package stdlib
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// Strings returns the `strings` module. Strings also have methods, such as
+// `name.upper()`, for the most common operations.
+func Strings() parser.Module {
+	return parser.Module{
+		Name: "strings",
+		Doc:  "Operations on strings. Lengths count characters rather than bytes.",
+		Functions: []parser.Function{
+			{
+				Name:   "concat",
+				Doc:    "Joins its arguments into a single string.",
+				Params: []parser.Param{variadic("parts", "string")},
+				Result: "string",
+				Func: func(parts ...string) string {
+					return strings.Join(parts, "")
+				},
+			},
+			{
+				Name:   "format",
+				Doc:    "Formats the arguments with a Go format string, such as \"%s: %.2f\".",
+				Params: []parser.Param{param("format", "string"), variadic("args", "any")},
+				Result: "string",
+				Func:   format,
+			},
+			{
+				Name:   "title",
+				Doc:    "Capitalizes the first letter of every word.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func:   title,
+			},
+			{
+				Name:   "truncate",
+				Doc:    "Shortens s to a length, ending with a suffix, \"...\" by default, if it is longer.",
+				Params: []parser.Param{param("s", "string"), param("length", "number"), optional("suffix", "string")},
+				Result: "string",
+				Func:   truncate,
+			},
+			{
+				Name:   "reverse",
+				Doc:    "Returns the characters of s in reverse order.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "string",
+				Func:   reverse,
+			},
+			{
+				Name:   "count",
+				Doc:    "Counts the occurrences of sub in s that do not overlap.",
+				Params: []parser.Param{param("s", "string"), param("sub", "string")},
+				Result: "number",
+				Func:   strings.Count,
+			},
+			{
+				Name:   "words",
+				Doc:    "Splits s around whitespace.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "array",
+				Func:   words,
+			},
+		},
+	}
+}
+
+// format formats the exported args with fmt. Formats whose widths and
+// precisions would pad the result beyond the string limit of the evaluator
+// are refused before fmt allocates the padding.
+func format(ctx context.Context, layout string, args ...interface{}) (string, error) {
+	if e, ok := parser.EvaluatorFrom(ctx); ok {
+		if limit := e.Limits().MaxStringLength; limit > 0 {
+			if padding := formatPadding(layout, args); padding > limit {
+				return "", &parser.StringLimitError{Limit: limit, Length: padding}
+			}
+		}
+	}
+	exported := make([]interface{}, len(args))
+	for i, arg := range args {
+		var err error
+		if exported[i], err = export(ctx, arg); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf(layout, exported...), nil
+}
+
+// formatPadding returns the sum of the widths and precisions of the verbs
+// of layout, including those that `*` reads from args.
+func formatPadding(layout string, args []interface{}) int {
+	padding, arg := 0, 0
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			continue
+		}
+		for i++; i < len(layout) && strings.IndexByte("+-# 0", layout[i]) >= 0; i++ {
+		}
+		// The width, then the precision after a dot. Either can be read
+		// from an argument, chosen by an index such as [2].
+		for part := 0; part < 2 && i < len(layout); part++ {
+			if part == 1 {
+				if layout[i] != '.' {
+					break
+				}
+				i++
+			}
+			i, arg = formatIndex(layout, i, arg)
+			if i < len(layout) && layout[i] == '*' {
+				if arg < len(args) {
+					padding += formatStar(args[arg])
+				}
+				arg++
+				i++
+				continue
+			}
+			n := 0
+			for ; i < len(layout) && layout[i] >= '0' && layout[i] <= '9'; i++ {
+				if n < 1e9 {
+					n = n*10 + int(layout[i]-'0')
+				}
+			}
+			padding += n
+		}
+		i, arg = formatIndex(layout, i, arg)
+		if i < len(layout) && layout[i] != '%' {
+			arg++
+		}
+	}
+	return padding
+}
+
+// formatIndex skips an argument index such as [2] at layout[i], and returns
+// the argument it designates.
+func formatIndex(layout string, i, arg int) (int, int) {
+	if i >= len(layout) || layout[i] != '[' {
+		return i, arg
+	}
+	end := strings.IndexByte(layout[i:], ']')
+	if end < 0 {
+		return i, arg
+	}
+	n, err := strconv.Atoi(layout[i+1 : i+end])
+	if err != nil {
+		return i + end + 1, arg
+	}
+	return i + end + 1, n - 1
+}
+
+// formatStar returns the width or precision that `*` reads from arg.
+func formatStar(arg interface{}) int {
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n > -1e9 && n < 1e9 {
+			if n < 0 {
+				return int(-n)
+			}
+			return int(n)
+		}
+		return 1e9
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := v.Uint(); n < 1e9 {
+			return int(n)
+		}
+		return 1e9
+	}
+	return 0
+}
+
+func title(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+func truncate(s string, length int, suffix ...string) (string, error) {
+	if len(suffix) > 1 {
+		return "", tooManyArguments("truncate", len(suffix))
+	}
+	end := "..."
+	if len(suffix) == 1 {
+		end = suffix[0]
+	}
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s, nil
+	}
+	keep := length - len([]rune(end))
+	if keep < 0 {
+		keep = 0
+	}
+	return string(runes[:keep]) + end, nil
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func words(s string) []interface{} {
+	return stringArray(strings.Fields(s))
+}
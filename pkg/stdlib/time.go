@@ -0,0 +1,94 @@
+package stdlib
+
+import (
+	"context"
+	"time"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+// Time returns the `time` module. clock is the clock of the module; if it
+// is nil, the module reads the clock of the evaluator, set with
+// Evaluator.SetClock, like the built-in now().
+func Time(clock func() time.Time) parser.Module {
+	now := func(ctx context.Context) time.Time {
+		if clock != nil {
+			return clock()
+		}
+		if e, ok := parser.EvaluatorFrom(ctx); ok {
+			return e.Now()
+		}
+		return time.Now()
+	}
+	return parser.Module{
+		Name: "time",
+		Doc:  "Dates and durations. Layouts are Go reference layouts, such as \"2006-01-02\".",
+		Functions: []parser.Function{
+			{
+				Name:   "now",
+				Doc:    "Returns the current time.",
+				Result: "time",
+				Func:   now,
+			},
+			{
+				Name:   "since",
+				Doc:    "Returns the time elapsed since t.",
+				Params: []parser.Param{param("t", "time")},
+				Result: "duration",
+				Func: func(ctx context.Context, t time.Time) time.Duration {
+					return now(ctx).Sub(t)
+				},
+			},
+			{
+				Name:   "date",
+				Doc:    "Returns midnight UTC of a date.",
+				Params: []parser.Param{param("year", "number"), param("month", "number"), param("day", "number")},
+				Result: "time",
+				Func: func(year, month, day int) time.Time {
+					return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+				},
+			},
+			{
+				Name:   "unix",
+				Doc:    "Returns the UTC time a number of seconds after January 1, 1970.",
+				Params: []parser.Param{param("seconds", "number")},
+				Result: "time",
+				Func: func(seconds int64) time.Time {
+					return time.Unix(seconds, 0).UTC()
+				},
+			},
+			{
+				Name:   "parse",
+				Doc:    "Parses a time formatted with a layout.",
+				Params: []parser.Param{param("layout", "string"), param("value", "string")},
+				Result: "time",
+				Func:   time.Parse,
+			},
+			{
+				Name:   "format",
+				Doc:    "Formats t with a layout.",
+				Params: []parser.Param{param("t", "time"), param("layout", "string")},
+				Result: "string",
+				Func: func(t time.Time, layout string) string {
+					return t.Format(layout)
+				},
+			},
+			{
+				Name:   "duration",
+				Doc:    "Parses a duration such as \"1h30m\".",
+				Params: []parser.Param{param("value", "string")},
+				Result: "duration",
+				Func:   time.ParseDuration,
+			},
+			{
+				Name:   "add",
+				Doc:    "Returns t plus a duration.",
+				Params: []parser.Param{param("t", "time"), param("d", "duration")},
+				Result: "time",
+				Func: func(t time.Time, d time.Duration) time.Time {
+					return t.Add(d)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package stdlib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"sync"
+
+	"github.com/nonsocode/xpress/pkg/parser"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// UUID returns the `uuid` module. UUIDs are read from source, crypto/rand if
+// it is nil; pass a seeded source such as a math/rand.Rand to generate the
+// same UUIDs on every run. Reads from source are serialized.
+func UUID(source io.Reader) parser.Module {
+	if source == nil {
+		source = rand.Reader
+	}
+	var lock sync.Mutex
+	return parser.Module{
+		Name: "uuid",
+		Doc:  "Random version 4 UUIDs.",
+		Functions: []parser.Function{
+			{
+				Name:   "new",
+				Doc:    "Returns a new version 4 UUID, such as \"1b4e28ba-2fa1-41d2-883f-0016d3cca427\".",
+				Result: "string",
+				Func: func() (string, error) {
+					var id [16]byte
+					lock.Lock()
+					_, err := io.ReadFull(source, id[:])
+					lock.Unlock()
+					if err != nil {
+						return "", err
+					}
+					id[6] = id[6]&0x0f | 0x40
+					id[8] = id[8]&0x3f | 0x80
+					return formatUUID(id), nil
+				},
+			},
+			{
+				Name:   "valid",
+				Doc:    "Reports whether s is a UUID in its canonical form.",
+				Params: []parser.Param{param("s", "string")},
+				Result: "bool",
+				Func:   uuidPattern.MatchString,
+			},
+		},
+	}
+}
+
+func formatUUID(id [16]byte) string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf[:])
+}